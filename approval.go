@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/x-qdo/qudosh/packages/approval"
+	"github.com/x-qdo/qudosh/packages/tty"
+)
+
+// approvalConfirmTimeout bounds the check of an approval by confirmApproval.
+const approvalConfirmTimeout = 30 * time.Second
+
+// awaitApproval holds the session back until a second person approves it
+// through the endpoint at QUDOSH_APPROVAL_URL, for at most
+// QUDOSH_APPROVAL_TIMEOUT. The approver is recorded in the metadata.
+func awaitApproval(metadata *tty.Metadata) error {
+	endpoint := os.Getenv("QUDOSH_APPROVAL_URL")
+	if endpoint == "" {
+		return nil
+	}
+
+	options := []approval.Option{
+		approval.WithToken(os.Getenv("QUDOSH_APPROVAL_TOKEN")),
+		approval.WithProgress(func(left time.Duration) {
+			fmt.Printf("\rqudosh: waiting for approval of session %s (%s left) ", metadata.SessionID, left.Round(time.Second))
+		}),
+	}
+	if timeout := os.Getenv("QUDOSH_APPROVAL_TIMEOUT"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return fmt.Errorf("invalid QUDOSH_APPROVAL_TIMEOUT: %w", err)
+		}
+		options = append(options, approval.WithTimeout(d))
+	}
+
+	status, err := approval.New(endpoint, options...).Wait(context.Background(), &approval.Request{
+		SessionID:   metadata.SessionID,
+		User:        metadata.User,
+		Host:        metadata.Host,
+		Shell:       metadata.Shell,
+		Argv:        metadata.Argv,
+		Reason:      metadata.Reason,
+		Ticket:      metadata.Ticket,
+		RequestedAt: time.Now(),
+	})
+	fmt.Println()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	metadata.Approver = status.Approver
+	metadata.ApprovedAt = &now
+	fmt.Printf("qudosh: session approved by %s\n", status.Approver)
+	return nil
+}
+
+// confirmApproval checks with the endpoint at QUDOSH_APPROVAL_URL that the
+// session has been approved, in the background process of a detachable
+// session. The approver is recorded as the endpoint tells, and the approval
+// time as the time it was confirmed.
+func confirmApproval(metadata *tty.Metadata) error {
+	endpoint := os.Getenv("QUDOSH_APPROVAL_URL")
+	if endpoint == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), approvalConfirmTimeout)
+	defer cancel()
+
+	status, err := approval.New(endpoint, approval.WithToken(os.Getenv("QUDOSH_APPROVAL_TOKEN"))).Confirm(ctx, &approval.Request{
+		SessionID: metadata.SessionID,
+		User:      metadata.User,
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	metadata.Approver = status.Approver
+	metadata.ApprovedAt = &now
+	return nil
+}
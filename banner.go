@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/x-qdo/qudosh/packages/tty"
+)
+
+// errBannerRefused is returned when the user did not accept the login banner.
+var errBannerRefused = errors.New("the login banner was not accepted")
+
+// handoffFD is the descriptor of the pipe the background process of a
+// detachable session reads its handoff from.
+const handoffFD = 3
+
+// handoff is what the foreground of a detachable session passes on to its
+// background process: the acceptance of the banner, which only the foreground
+// can show. It goes through an inherited pipe, see writeHandoff: anybody can
+// set the environment of a process they start.
+type handoff struct {
+	BannerSHA256     string     `json:"banner_sha256,omitempty"`
+	BannerAcceptedAt *time.Time `json:"banner_accepted_at,omitempty"`
+}
+
+// acknowledgeBanner shows the login banner of QUDOSH_BANNER and requires the
+// user to accept it, by typing "yes" or, with QUDOSH_BANNER_ACCEPT=key, by
+// pressing y. The hash of the banner and the time of acceptance are recorded
+// in the metadata.
+func acknowledgeBanner(metadata *tty.Metadata) error {
+	path := os.Getenv("QUDOSH_BANNER")
+	if path == "" {
+		return nil
+	}
+
+	banner, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("%w: no terminal to show it on", errBannerRefused)
+	}
+
+	fmt.Print(strings.TrimRight(string(banner), "\n") + "\n\n")
+
+	accepted, err := acceptBanner(os.Stdin, os.Getenv("QUDOSH_BANNER_ACCEPT"))
+	if err != nil {
+		return err
+	}
+	if !accepted {
+		return errBannerRefused
+	}
+
+	now := time.Now()
+	metadata.BannerSHA256 = bannerHash(banner)
+	metadata.BannerAcceptedAt = &now
+	return nil
+}
+
+func bannerHash(banner []byte) string {
+	sum := sha256.Sum256(banner)
+	return hex.EncodeToString(sum[:])
+}
+
+// acceptBanner asks the user to accept the banner on input, in the way
+// selected by QUDOSH_BANNER_ACCEPT.
+func acceptBanner(input io.Reader, mode string) (bool, error) {
+	switch mode {
+	case "", "yes":
+		return acceptByTyping(input)
+	case "key":
+		return acceptByKey(input)
+	}
+	return false, fmt.Errorf("invalid QUDOSH_BANNER_ACCEPT %q", mode)
+}
+
+func acceptByTyping(input io.Reader) (bool, error) {
+	answer, err := prompt(input, `Type "yes" to accept: `)
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(answer, "yes"), nil
+}
+
+func acceptByKey(input io.Reader) (bool, error) {
+	fmt.Print("Press y to accept, any other key to leave: ")
+
+	// A single key is read from a terminal in raw mode, without waiting for Enter.
+	if f, ok := input.(*os.File); ok && terminal.IsTerminal(int(f.Fd())) {
+		oldState, err := terminal.MakeRaw(int(f.Fd()))
+		if err != nil {
+			return false, err
+		}
+		defer terminal.Restore(int(f.Fd()), oldState)
+	}
+	key := make([]byte, 1)
+	_, err := io.ReadFull(input, key)
+	fmt.Println()
+	if err != nil {
+		return false, err
+	}
+
+	return key[0] == 'y' || key[0] == 'Y', nil
+}
+
+// writeHandoff passes the acceptance of the banner on to the background
+// process of a detachable session, see readHandoff.
+func writeHandoff(w io.Writer, metadata *tty.Metadata) error {
+	return json.NewEncoder(w).Encode(handoff{
+		BannerSHA256:     metadata.BannerSHA256,
+		BannerAcceptedAt: metadata.BannerAcceptedAt,
+	})
+}
+
+// readHandoff records what the foreground of a detachable session passed on,
+// see handoff. Without it, nobody saw the banner and the session is refused.
+func readHandoff(metadata *tty.Metadata) error {
+	f := os.NewFile(handoffFD, "handoff")
+	if f == nil {
+		return errors.New("no handoff from the foreground of the session")
+	}
+	defer f.Close()
+
+	if info, err := f.Stat(); err != nil || info.Mode()&os.ModeNamedPipe == 0 {
+		return errors.New("no handoff from the foreground of the session")
+	}
+	return decodeHandoff(f, metadata)
+}
+
+// decodeHandoff reads the handoff from r. It is refused when the banner of
+// QUDOSH_BANNER is not the one accepted in the foreground.
+func decodeHandoff(r io.Reader, metadata *tty.Metadata) error {
+	var h handoff
+	if err := json.NewDecoder(r).Decode(&h); err != nil {
+		return fmt.Errorf("invalid handoff from the foreground of the session: %w", err)
+	}
+
+	if path := os.Getenv("QUDOSH_BANNER"); path != "" {
+		banner, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if h.BannerAcceptedAt == nil || h.BannerSHA256 != bannerHash(banner) {
+			return errBannerRefused
+		}
+	}
+
+	metadata.BannerSHA256 = h.BannerSHA256
+	metadata.BannerAcceptedAt = h.BannerAcceptedAt
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/x-qdo/qudosh/packages/tty"
+)
+
+func TestAcceptBanner(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		input   string
+		want    bool
+		wantErr error
+	}{
+		{name: "accepted", input: "yes\n", want: true},
+		{name: "accepted in capitals", mode: "yes", input: " YES \n", want: true},
+		{name: "refused", input: "no\n"},
+		{name: "empty line", input: "\n"},
+		{name: "empty input", input: "", wantErr: io.EOF},
+		{name: "key accepted", mode: "key", input: "y", want: true},
+		{name: "key refused", mode: "key", input: "n"},
+		{name: "key empty input", mode: "key", input: "", wantErr: io.EOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accepted, err := acceptBanner(strings.NewReader(tt.input), tt.mode)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if accepted != tt.want {
+				t.Errorf("got accepted %v, want %v", accepted, tt.want)
+			}
+		})
+	}
+
+	if _, err := acceptBanner(strings.NewReader("yes\n"), "maybe"); err == nil {
+		t.Error("invalid mode accepted")
+	}
+}
+
+func TestDecodeHandoff(t *testing.T) {
+	banner := filepath.Join(t.TempDir(), "banner")
+	if err := os.WriteFile(banner, []byte("Authorised use only.\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	acceptedAt := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	accepted := &tty.Metadata{
+		BannerSHA256:     bannerHash([]byte("Authorised use only.\n")),
+		BannerAcceptedAt: &acceptedAt,
+	}
+
+	tests := []struct {
+		name     string
+		banner   string
+		handoff  *tty.Metadata
+		raw      string
+		wantErr  error
+		wantHash string
+	}{
+		{name: "accepted", banner: banner, handoff: accepted, wantHash: accepted.BannerSHA256},
+		{name: "without banner", handoff: &tty.Metadata{}},
+		{name: "not accepted", banner: banner, handoff: &tty.Metadata{}, wantErr: errBannerRefused},
+		{name: "other banner", banner: banner, handoff: &tty.Metadata{
+			BannerSHA256:     bannerHash([]byte("Welcome!\n")),
+			BannerAcceptedAt: &acceptedAt,
+		}, wantErr: errBannerRefused},
+		{name: "not accepted in time", banner: banner, handoff: &tty.Metadata{
+			BannerSHA256: accepted.BannerSHA256,
+		}, wantErr: errBannerRefused},
+		{name: "empty", banner: banner, raw: ""},
+		{name: "invalid", banner: banner, raw: "banner accepted\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("QUDOSH_BANNER", tt.banner)
+
+			var pipe bytes.Buffer
+			if tt.handoff != nil {
+				if err := writeHandoff(&pipe, tt.handoff); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				pipe.WriteString(tt.raw)
+			}
+
+			var metadata tty.Metadata
+			err := decodeHandoff(&pipe, &metadata)
+			if tt.handoff == nil {
+				if err == nil {
+					t.Fatal("invalid handoff accepted")
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if metadata.BannerSHA256 != tt.wantHash {
+				t.Errorf("got banner hash %q, want %q", metadata.BannerSHA256, tt.wantHash)
+			}
+			if tt.wantHash != "" && !metadata.BannerAcceptedAt.Equal(acceptedAt) {
+				t.Errorf("got acceptance time %v, want %v", metadata.BannerAcceptedAt, acceptedAt)
+			}
+		})
+	}
+}
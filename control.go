@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/x-qdo/qudosh/packages/tty"
+)
+
+// control lets a supervisor intervene in a running session:
+// qudosh control <session> freeze|unfreeze|notice|terminate [message].
+func control(args []string) int {
+	if len(args) < 2 {
+		fmt.Println("usage: qudosh control <session> freeze|unfreeze|notice|terminate [message]")
+		return 2
+	}
+
+	socket, err := sessionSocket(args[0])
+	if err != nil {
+		return exit(err, 1)
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return exit(err, 1)
+	}
+	defer conn.Close()
+
+	if err := tty.WriteHello(conn, tty.Hello{Mode: tty.ModeControl, User: currentUser()}); err != nil {
+		return exit(err, 1)
+	}
+
+	request, err := json.Marshal(tty.ControlRequest{
+		Action:  tty.ControlAction(args[1]),
+		Message: strings.Join(args[2:], " "),
+	})
+	if err != nil {
+		return exit(err, 1)
+	}
+	if err := tty.WriteMessage(conn, tty.MessageControl, request); err != nil {
+		return exit(err, 1)
+	}
+
+	t, payload, err := tty.ReadMessage(conn)
+	if err != nil {
+		return exit(err, 1)
+	}
+	if t != tty.MessageControl {
+		return exit(fmt.Errorf("unexpected message %q", t), 1)
+	}
+	var reply tty.ControlReply
+	if err := json.Unmarshal(payload, &reply); err != nil {
+		return exit(err, 1)
+	}
+	if reply.Error != "" {
+		return exit(errors.New(reply.Error), 1)
+	}
+
+	return 0
+}
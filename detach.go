@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+
+	"github.com/x-qdo/qudosh/packages/tty"
+)
+
+const (
+	// daemonEnv is set for the background process running a detachable session.
+	daemonEnv = "QUDOSH_DAEMON"
+
+	// daemonStartTimeout is how long the foreground waits for the session socket.
+	daemonStartTimeout = 5 * time.Second
+)
+
+// attach reconnects to a detached session: qudosh attach <session>.
+// Without a session, the running sessions are listed.
+func attach(args []string) int {
+	return attachSession(args, tty.ModeAttach)
+}
+
+// detachable reports whether the session should run in a background process,
+// so that it survives the client detaching or hanging up.
+func detachable() bool {
+	return os.Getenv("QUDOSH_DETACHABLE") != "" && os.Getenv(daemonEnv) == ""
+}
+
+// daemonized reports whether this is the background process of a session.
+func daemonized() bool {
+	return os.Getenv(daemonEnv) != ""
+}
+
+// startDetached runs the session in a background process, which exposes it on
+// its socket, and attaches the current terminal to it. Detaching or losing the
+// terminal leaves the shell and its recording running until `qudosh attach`.
+func startDetached(metadata *tty.Metadata) int {
+	sessionID := metadata.SessionID
+	dir, err := makeSocketDir()
+	if err != nil {
+		return exit(err, 3)
+	}
+
+	logFile, err := os.OpenFile(filepath.Join(dir, sessionID+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return exit(err, 3)
+	}
+	defer logFile.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		return exit(err, 3)
+	}
+
+	env := append(os.Environ(),
+		daemonEnv+"=1",
+		"QUDOSH_SESSION_ID="+sessionID,
+		"QUDOSH_WATCH=1",
+	)
+	env = append(env, justificationEnv(metadata)...)
+	if rows, cols, err := pty.Getsize(os.Stdin); err == nil {
+		env = append(env, fmt.Sprintf("QUDOSH_INITIAL_SIZE=%dx%d", cols, rows))
+	}
+
+	handoffReader, handoffWriter, err := os.Pipe()
+	if err != nil {
+		return exit(err, 3)
+	}
+
+	// Args keeps argv[0], which selects the shell under QUDOSH_SHELL_PATH.
+	cmd := &exec.Cmd{
+		Path:        executable,
+		Args:        os.Args,
+		Env:         env,
+		Stdout:      logFile,
+		Stderr:      logFile,
+		ExtraFiles:  []*os.File{handoffReader},
+		SysProcAttr: &syscall.SysProcAttr{Setsid: true},
+	}
+	err = cmd.Start()
+	handoffReader.Close()
+	if err != nil {
+		handoffWriter.Close()
+		return exit(err, 3)
+	}
+
+	err = writeHandoff(handoffWriter, metadata)
+	handoffWriter.Close()
+	if err != nil {
+		return exit(err, 3)
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	socket := filepath.Join(dir, sessionID+".sock")
+	deadline := time.After(daemonStartTimeout)
+	for {
+		if _, err := os.Stat(socket); err == nil {
+			break
+		}
+		select {
+		case <-exited:
+			return exit(fmt.Errorf("session failed to start, see %s", logFile.Name()), 3)
+		case <-deadline:
+			return exit(fmt.Errorf("timed out waiting for %s", socket), 3)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	fmt.Printf("qudosh: session %s, reattach with `qudosh attach %s`\n", sessionID, sessionID)
+	return attachSession([]string{sessionID}, tty.ModeAttach)
+}
+
+// initialSize parses QUDOSH_INITIAL_SIZE, the COLUMNSxROWS size of the
+// terminal that started a detachable session.
+func initialSize() (tty.TerminalSize, bool) {
+	value := os.Getenv("QUDOSH_INITIAL_SIZE")
+	cols, rows, ok := strings.Cut(value, "x")
+	if !ok {
+		return tty.TerminalSize{}, false
+	}
+
+	c, err := strconv.Atoi(cols)
+	if err != nil {
+		return tty.TerminalSize{}, false
+	}
+	r, err := strconv.Atoi(rows)
+	if err != nil {
+		return tty.TerminalSize{}, false
+	}
+	return tty.TerminalSize{Columns: c, Rows: r}, true
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/x-qdo/qudosh/packages/tty"
+)
+
+func TestInitialSize(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   tty.TerminalSize
+		wantOK bool
+	}{
+		{value: "120x40", want: tty.TerminalSize{Columns: 120, Rows: 40}, wantOK: true},
+		{value: ""},
+		{value: "120"},
+		{value: "x40"},
+		{value: "120x"},
+		{value: "wide x40"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("QUDOSH_INITIAL_SIZE", tt.value)
+			size, ok := initialSize()
+			if ok != tt.wantOK || size != tt.want {
+				t.Errorf("got %+v, %v, want %+v, %v", size, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/x-qdo/qudosh/packages/tty"
+)
+
+const (
+	// defaultTicketPattern matches issue keys such as OPS-1234.
+	defaultTicketPattern = `^[A-Z][A-Z0-9]*-[0-9]+$`
+
+	// justificationAttempts is how often an invalid ticket may be entered.
+	justificationAttempts = 3
+
+	// maxTagValueLen is the longest value of an S3 object tag.
+	maxTagValueLen = 256
+)
+
+// justify records why the session is opened. With QUDOSH_REQUIRE_JUSTIFICATION
+// set, the reason and a ticket matching QUDOSH_TICKET_PATTERN are required.
+// They are taken from QUDOSH_REASON and QUDOSH_TICKET, or asked for.
+func justify(metadata *tty.Metadata) error {
+	reason := strings.TrimSpace(os.Getenv("QUDOSH_REASON"))
+	ticket := strings.TrimSpace(os.Getenv("QUDOSH_TICKET"))
+	if os.Getenv("QUDOSH_REQUIRE_JUSTIFICATION") == "" {
+		metadata.Reason, metadata.Ticket = reason, ticket
+		return nil
+	}
+
+	pattern := defaultTicketPattern
+	if p := os.Getenv("QUDOSH_TICKET_PATTERN"); p != "" {
+		pattern = p
+	}
+	ticketRegex, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid QUDOSH_TICKET_PATTERN: %w", err)
+	}
+
+	if reason == "" || !ticketRegex.MatchString(ticket) {
+		if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+			return fmt.Errorf("a reason and a ticket matching %s are required, see QUDOSH_REASON and QUDOSH_TICKET", pattern)
+		}
+
+		for reason == "" {
+			if reason, err = prompt(os.Stdin, "Reason for this session: "); err != nil {
+				return err
+			}
+		}
+		for attempt := 0; !ticketRegex.MatchString(ticket); attempt++ {
+			if attempt == justificationAttempts {
+				return fmt.Errorf("no ticket matching %s given", pattern)
+			}
+			if ticket, err = prompt(os.Stdin, "Ticket: "); err != nil {
+				return err
+			}
+		}
+	}
+
+	metadata.Reason, metadata.Ticket = reason, ticket
+	return nil
+}
+
+// prompt asks question and reads the answer from input one byte at a time:
+// anything typed ahead is left for the next prompt or the shell.
+func prompt(input io.Reader, question string) (string, error) {
+	fmt.Print(question)
+
+	var (
+		line []byte
+		b    = make([]byte, 1)
+	)
+	for {
+		n, err := input.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSpace(string(line)), nil
+}
+
+// justificationEnv passes the justification of a detachable session on to its background process.
+func justificationEnv(metadata *tty.Metadata) []string {
+	return []string{
+		"QUDOSH_REASON=" + metadata.Reason,
+		"QUDOSH_TICKET=" + metadata.Ticket,
+	}
+}
+
+// s3Tags returns the S3 object tags of the recordings, or nil without any.
+func s3Tags(metadata *tty.Metadata) *string {
+	tags := url.Values{}
+	if metadata.Reason != "" {
+		tags.Set("reason", tagValue(metadata.Reason))
+	}
+	if metadata.Ticket != "" {
+		tags.Set("ticket", tagValue(metadata.Ticket))
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	return aws.String(tags.Encode())
+}
+
+// tagValue replaces the characters S3 does not allow in tag values with
+// spaces and truncates the value to the maximum length.
+func tagValue(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), strings.ContainsRune(" +-=._:/@", r):
+			return r
+		default:
+			return ' '
+		}
+	}, s)
+	if runes := []rune(s); len(runes) > maxTagValueLen {
+		s = string(runes[:maxTagValueLen])
+	}
+	return s
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/x-qdo/qudosh/packages/tty"
+)
+
+func TestPrompt(t *testing.T) {
+	// Typed ahead: the banner, then the reason of the session.
+	input := strings.NewReader("yes\n fixing the backup \nls\n")
+
+	for _, want := range []string{"yes", "fixing the backup"} {
+		answer, err := prompt(input, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if answer != want {
+			t.Errorf("got %q, want %q", answer, want)
+		}
+	}
+	if input.Len() != len("ls\n") {
+		t.Errorf("prompts consumed input left for the shell, %d bytes left", input.Len())
+	}
+}
+
+func TestTagValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "allowed", value: "OPS-1234 fix db:replica/2 @alice +=_.", want: "OPS-1234 fix db:replica/2 @alice +=_."},
+		{name: "disallowed", value: "a,b;c\"d\ne<f>", want: "a b c d e f "},
+		{name: "letters", value: "Störung über Nacht", want: "Störung über Nacht"},
+		{name: "truncated", value: strings.Repeat("ä", maxTagValueLen+10), want: strings.Repeat("ä", maxTagValueLen)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tagValue(tt.value)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if n := utf8.RuneCountInString(got); n > maxTagValueLen {
+				t.Errorf("got %d runes, want at most %d", n, maxTagValueLen)
+			}
+		})
+	}
+}
+
+func TestS3Tags(t *testing.T) {
+	if tags := s3Tags(&tty.Metadata{}); tags != nil {
+		t.Errorf("got tags %q without a reason or ticket", *tags)
+	}
+
+	tags := s3Tags(&tty.Metadata{Reason: "restore, then verify", Ticket: "OPS-1"})
+	if tags == nil {
+		t.Fatal("no tags")
+	}
+	values, err := url.ParseQuery(*tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values.Get("reason") != "restore  then verify" || values.Get("ticket") != "OPS-1" {
+		t.Errorf("unexpected tags %q", *tags)
+	}
+
+	tags = s3Tags(&tty.Metadata{Ticket: "OPS-2"})
+	if tags == nil || *tags != "ticket=OPS-2" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+}
@@ -2,12 +2,18 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/creack/pty"
 	"golang.org/x/crypto/ssh/terminal"
+	"io"
 	"os"
 	"os/signal"
+	"os/user"
 	"path/filepath"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -20,7 +26,25 @@ import (
 	"github.com/x-qdo/qudosh/packages/tty"
 )
 
+// version is set at build time by goreleaser.
+var version = "dev"
+
+// commands are the subcommands available when qudosh is run under its own name.
+var commands = map[string]func(args []string) int{
+	"watch":   watch,
+	"join":    join,
+	"attach":  attach,
+	"control": control,
+	"stats":   statsCommand,
+}
+
 func main() {
+	if filepath.Base(os.Args[0]) == "qudosh" && len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+
 	os.Exit(process())
 }
 
@@ -38,6 +62,36 @@ func process() int {
 		shell = filepath.Join(shellPath, filepath.Base(os.Args[0]))
 	}
 	arguments := os.Args[1:]
+	daemon := daemonized()
+	metadata := sessionMetadata(shell, arguments)
+
+	// The background process of a detachable session was acknowledged and
+	// approved in the foreground already. It takes the acceptance of the
+	// banner from the foreground and confirms the approval with the endpoint.
+	if daemon {
+		if err := readHandoff(metadata); err != nil {
+			return exit(err, 4)
+		}
+	} else if err := acknowledgeBanner(metadata); err != nil {
+		return exit(err, 4)
+	}
+
+	if err := justify(metadata); err != nil {
+		return exit(err, 4)
+	}
+
+	if daemon {
+		err = confirmApproval(metadata)
+	} else {
+		err = awaitApproval(metadata)
+	}
+	if err != nil {
+		return exit(err, 4)
+	}
+
+	if detachable() {
+		return startDetached(metadata)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -52,12 +106,14 @@ func process() int {
 	defer slave.Close()
 
 	// We need to make sure that we will read each symbol separately
-	oldState, err := terminal.MakeRaw(int(os.Stdin.Fd()))
-	if err != nil {
-		cancel()
-		return exit(err, 1)
+	if !daemon {
+		oldState, err := terminal.MakeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			cancel()
+			return exit(err, 1)
+		}
+		defer terminal.Restore(int(os.Stdin.Fd()), oldState)
 	}
-	defer terminal.Restore(int(os.Stdin.Fd()), oldState)
 
 	timeNow := time.Now().Format("2006_02_01_15_04_05")
 	fileName := fmt.Sprintf("lab/session_%s.ttyrec", timeNow)
@@ -67,28 +123,143 @@ func process() int {
 		storePrefix = "."
 	}
 
+	recorderOptions, err := rotationOptions()
+	if err != nil {
+		cancel()
+		return exit(err, 3)
+	}
+	coalesce, err := coalesceOptions()
+	if err != nil {
+		cancel()
+		return exit(err, 3)
+	}
+	recorderOptions = append(recorderOptions, coalesce...)
+	queue, err := queueOptions()
+	if err != nil {
+		cancel()
+		return exit(err, 3)
+	}
+	recorderOptions = append(recorderOptions, queue...)
+	durability, err := syncOptions()
+	if err != nil {
+		cancel()
+		return exit(err, 3)
+	}
+	recorderOptions = append(recorderOptions, durability...)
+	sampling, err := metricsOptions()
+	if err != nil {
+		cancel()
+		return exit(err, 3)
+	}
+	recorderOptions = append(recorderOptions, sampling...)
+	recorderOptions = append(recorderOptions, tty.WithMetadata(metadata))
+
+	exporter, stopMetrics := exportMetrics(metadata.SessionID)
+	defer stopMetrics()
+	if exporter != nil {
+		recorderOptions = append(recorderOptions, tty.WithRegistry(exporter.Registry))
+	}
+
+	ttyOptions := []tty.Option{
+		tty.WithPermitWrite(),
+		tty.WithOwner(metadata.User),
+		tty.WithTtyRecording(ctx, storePrefix, fileName, saveFileHandler(metadata), recorderOptions...),
+	}
+	ttyOptions = append(ttyOptions, auditOptions()...)
+	if exporter != nil {
+		ttyOptions = append(ttyOptions, tty.WithObserver(exporter))
+	}
+
+	if timeout := os.Getenv("QUDOSH_IDLE_TIMEOUT"); timeout != "" {
+		option, err := idleTimeoutOption(timeout, os.Getenv("QUDOSH_IDLE_WARNING"))
+		if err != nil {
+			cancel()
+			return exit(err, 3)
+		}
+		ttyOptions = append(ttyOptions, option)
+	}
+
+	expiryOptions, err := expiryOptions()
+	if err != nil {
+		cancel()
+		return exit(err, 3)
+	}
+	ttyOptions = append(ttyOptions, expiryOptions...)
+
+	if os.Getenv("QUDOSH_WATCH") != "" {
+		options, err := socketOptions(metadata.SessionID)
+		if err != nil {
+			cancel()
+			return exit(err, 3)
+		}
+		ttyOptions = append(ttyOptions, options...)
+	}
+
+	floodOptions, err := floodOptions()
+	if err != nil {
+		cancel()
+		return exit(err, 3)
+	}
+	ttyOptions = append(ttyOptions, floodOptions...)
+
+	if os.Getenv("QUDOSH_SANITIZE") != "" {
+		policy, err := tty.ParseSanitizePolicy(os.Getenv("QUDOSH_SANITIZE_POLICY"))
+		if err != nil {
+			cancel()
+			return exit(fmt.Errorf("invalid QUDOSH_SANITIZE_POLICY: %w", err), 3)
+		}
+		ttyOptions = append(ttyOptions, tty.WithSanitizer(policy))
+	}
+
+	if policyFile := os.Getenv("QUDOSH_POLICY"); policyFile != "" {
+		policy, err := tty.LoadPolicy(policyFile)
+		if err != nil {
+			cancel()
+			return exit(err, 3)
+		}
+		ttyOptions = append(ttyOptions, tty.WithCommandPolicy(policy, metadata.User))
+	}
+
+	// The background process of a detachable session has no terminal,
+	// the owner attaches through the session socket instead.
+	var masterStdin io.Reader = os.Stdin
+	var masterStdout io.Writer = os.Stdout
+	if daemon {
+		masterStdin, masterStdout = nil, nil
+	}
+
 	proxyTTY, err := tty.New(
-		os.Stdin,
-		os.Stdout,
+		masterStdin,
+		masterStdout,
 		slave,
-		tty.WithPermitWrite(),
-		tty.WithTtyRecording(ctx, storePrefix, fileName, saveFileHandler()),
+		ttyOptions...,
 	)
+	if err != nil {
+		cancel()
+		return exit(err, 3)
+	}
 
-	sigwinch := make(chan os.Signal, 1)
-	signal.Notify(sigwinch, syscall.SIGWINCH)
+	if daemon {
+		size := metadata.InitialSize
+		if size.Columns > 0 && size.Rows > 0 {
+			proxyTTY.ResizeEvents <- &tty.ArgResizeTerminal{Columns: size.Columns, Rows: size.Rows}
+		}
+	} else {
+		sigwinch := make(chan os.Signal, 1)
+		signal.Notify(sigwinch, syscall.SIGWINCH)
 
-	go func() {
-		// Send initial resize at start
-		_ = resizeBasedOnCurrentShell(os.Stdin, proxyTTY.ResizeEvents)
+		go func() {
+			// Send initial resize at start
+			_ = resizeBasedOnCurrentShell(os.Stdin, proxyTTY.ResizeEvents)
 
-		for {
-			select {
-			case <-sigwinch:
-				_ = resizeBasedOnCurrentShell(os.Stdin, proxyTTY.ResizeEvents)
+			for {
+				select {
+				case <-sigwinch:
+					_ = resizeBasedOnCurrentShell(os.Stdin, proxyTTY.ResizeEvents)
+				}
 			}
-		}
-	}()
+		}()
+	}
 
 	errs := make(chan error, 1)
 	go func() {
@@ -96,7 +267,7 @@ func process() int {
 	}()
 
 	err = waitSignals(errs, cancel)
-	if err != nil && err != context.Canceled {
+	if err != nil && !errors.Is(err, context.Canceled) {
 		return exit(err, 8)
 	}
 
@@ -113,17 +284,279 @@ func resizeBasedOnCurrentShell(stdin *os.File, resizeEvents chan *tty.ArgResizeT
 	return nil
 }
 
-func saveFileHandler() tty.Hook {
-	return func(r *tty.Recorder) error {
+// sessionMetadata collects the context of the session for the recording sidecar.
+func sessionMetadata(shell string, arguments []string) *tty.Metadata {
+	sessionID := os.Getenv("QUDOSH_SESSION_ID")
+	if sessionID == "" {
+		sessionID = newSessionID()
+	}
+
+	m := &tty.Metadata{
+		SessionID: sessionID,
+		UID:       strconv.Itoa(os.Getuid()),
+		Shell:     shell,
+		Argv:      arguments,
+		Term:      os.Getenv("TERM"),
+		Version:   version,
+	}
+
+	if u, err := user.Current(); err == nil {
+		m.User = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		m.Host = host
+	}
+	if rows, cols, err := pty.Getsize(os.Stdin); err == nil {
+		m.InitialSize = tty.TerminalSize{Columns: cols, Rows: rows}
+	} else if size, ok := initialSize(); ok {
+		m.InitialSize = size
+	}
+	return m
+}
+
+func newSessionID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(id)
+}
+
+// idleTimeoutOption parses the idle timeout and its warning period,
+// which defaults to a minute but at most half of the timeout.
+func idleTimeoutOption(timeout, warning string) (tty.Option, error) {
+	t, err := time.ParseDuration(timeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid QUDOSH_IDLE_TIMEOUT: %w", err)
+	}
+
+	w := time.Minute
+	if w > t/2 {
+		w = t / 2
+	}
+	if warning != "" {
+		if w, err = time.ParseDuration(warning); err != nil {
+			return nil, fmt.Errorf("invalid QUDOSH_IDLE_WARNING: %w", err)
+		}
+	}
+
+	return tty.WithIdleTimeout(t, w), nil
+}
+
+// expiryOptions limits the session to QUDOSH_MAX_DURATION (a duration such as "4h")
+// and QUDOSH_EXPIRES_AT (an RFC 3339 time), whichever comes first.
+func expiryOptions() ([]tty.Option, error) {
+	var options []tty.Option
+
+	if maxDuration := os.Getenv("QUDOSH_MAX_DURATION"); maxDuration != "" {
+		d, err := time.ParseDuration(maxDuration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid QUDOSH_MAX_DURATION: %w", err)
+		}
+		options = append(options, tty.WithMaxDuration(d))
+	}
+
+	if expiresAt := os.Getenv("QUDOSH_EXPIRES_AT"); expiresAt != "" {
+		t, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid QUDOSH_EXPIRES_AT: %w", err)
+		}
+		options = append(options, tty.WithExpiry(t))
+	}
+
+	return options, nil
+}
+
+// floodOptions limits the output shown to QUDOSH_OUTPUT_RATE bytes per second
+// with bursts of QUDOSH_OUTPUT_BURST bytes, and summarises the recording while
+// the output exceeds QUDOSH_FLOOD_THRESHOLD bytes per second.
+func floodOptions() ([]tty.Option, error) {
+	var options []tty.Option
+
+	if rate := os.Getenv("QUDOSH_OUTPUT_RATE"); rate != "" {
+		r, err := strconv.Atoi(rate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid QUDOSH_OUTPUT_RATE: %w", err)
+		}
+		burst := r
+		if value := os.Getenv("QUDOSH_OUTPUT_BURST"); value != "" {
+			if burst, err = strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("invalid QUDOSH_OUTPUT_BURST: %w", err)
+			}
+		}
+		options = append(options, tty.WithOutputRateLimit(r, burst))
+	}
+
+	if threshold := os.Getenv("QUDOSH_FLOOD_THRESHOLD"); threshold != "" {
+		t, err := strconv.Atoi(threshold)
+		if err != nil {
+			return nil, fmt.Errorf("invalid QUDOSH_FLOOD_THRESHOLD: %w", err)
+		}
+		options = append(options, tty.WithFloodSummary(t))
+	}
+
+	return options, nil
+}
+
+// rotationOptions configures segment rotation from QUDOSH_ROTATE_SIZE (bytes)
+// and QUDOSH_ROTATE_INTERVAL (a duration such as "1h").
+func rotationOptions() ([]tty.RecorderOption, error) {
+	var options []tty.RecorderOption
+
+	if size := os.Getenv("QUDOSH_ROTATE_SIZE"); size != "" {
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid QUDOSH_ROTATE_SIZE: %w", err)
+		}
+		options = append(options, tty.RotateBySize(n))
+	}
+
+	if interval := os.Getenv("QUDOSH_ROTATE_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid QUDOSH_ROTATE_INTERVAL: %w", err)
+		}
+		options = append(options, tty.RotateByDuration(d))
+	}
+
+	return options, nil
+}
+
+// coalesceOptions merges the output recorded within QUDOSH_COALESCE_WINDOW
+// (a duration such as "20ms") into frames of at most QUDOSH_COALESCE_SIZE bytes.
+func coalesceOptions() ([]tty.RecorderOption, error) {
+	window := os.Getenv("QUDOSH_COALESCE_WINDOW")
+	if window == "" {
+		return nil, nil
+	}
+
+	d, err := time.ParseDuration(window)
+	if err != nil {
+		return nil, fmt.Errorf("invalid QUDOSH_COALESCE_WINDOW: %w", err)
+	}
+
+	var size int
+	if value := os.Getenv("QUDOSH_COALESCE_SIZE"); value != "" {
+		if size, err = strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("invalid QUDOSH_COALESCE_SIZE: %w", err)
+		}
+	}
+
+	return []tty.RecorderOption{tty.CoalesceFrames(d, size)}, nil
+}
+
+// queueOptions moves the writes of the recording to a queue of QUDOSH_RECORD_QUEUE
+// bytes, full queues being handled according to QUDOSH_RECORD_QUEUE_POLICY.
+func queueOptions() ([]tty.RecorderOption, error) {
+	size, policy := os.Getenv("QUDOSH_RECORD_QUEUE"), os.Getenv("QUDOSH_RECORD_QUEUE_POLICY")
+	if size == "" && policy == "" {
+		return nil, nil
+	}
+
+	var (
+		n   int
+		err error
+	)
+	if size != "" {
+		if n, err = strconv.Atoi(size); err != nil {
+			return nil, fmt.Errorf("invalid QUDOSH_RECORD_QUEUE: %w", err)
+		}
+	}
+
+	p := tty.QueueBlock
+	if policy != "" {
+		if p, err = tty.ParseQueuePolicy(policy); err != nil {
+			return nil, fmt.Errorf("invalid QUDOSH_RECORD_QUEUE_POLICY: %w", err)
+		}
+	}
+
+	return []tty.RecorderOption{tty.WriteAsync(n, p)}, nil
+}
+
+// syncOptions flushes the recording to disk every QUDOSH_SYNC_INTERVAL (a duration),
+// every QUDOSH_SYNC_SIZE bytes and, with QUDOSH_SYNC_COMMANDS set, after every command.
+func syncOptions() ([]tty.RecorderOption, error) {
+	var options []tty.RecorderOption
+
+	if interval := os.Getenv("QUDOSH_SYNC_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid QUDOSH_SYNC_INTERVAL: %w", err)
+		}
+		options = append(options, tty.SyncByDuration(d))
+	}
+
+	if size := os.Getenv("QUDOSH_SYNC_SIZE"); size != "" {
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid QUDOSH_SYNC_SIZE: %w", err)
+		}
+		options = append(options, tty.SyncBySize(n))
+	}
+
+	if os.Getenv("QUDOSH_SYNC_COMMANDS") != "" {
+		options = append(options, tty.SyncOnCommand())
+	}
+
+	return options, nil
+}
+
+// auditOptions writes the commands reported by the shell to the audit log with
+// QUDOSH_AUDIT_COMMANDS set, and the lines reconstructed from the keystrokes
+// with QUDOSH_AUDIT_INPUT set.
+func auditOptions() []tty.Option {
+	var options []tty.Option
+
+	if os.Getenv("QUDOSH_AUDIT_COMMANDS") != "" {
+		options = append(options, tty.WithCommandAudit())
+	}
+
+	if os.Getenv("QUDOSH_AUDIT_INPUT") != "" {
+		options = append(options, tty.WithInputAudit())
+	}
+
+	return options
+}
+
+// metricsOptions samples the activity metrics every QUDOSH_METRICS_INTERVAL
+// (a duration) in QUDOSH_METRICS_FORMAT.
+func metricsOptions() ([]tty.RecorderOption, error) {
+	var options []tty.RecorderOption
+
+	if interval := os.Getenv("QUDOSH_METRICS_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid QUDOSH_METRICS_INTERVAL: %q", interval)
+		}
+		options = append(options, tty.WithMetricsInterval(d))
+	}
+
+	if format := os.Getenv("QUDOSH_METRICS_FORMAT"); format != "" {
+		f, err := tty.ParseMetricsFormat(format)
+		if err != nil {
+			return nil, fmt.Errorf("invalid QUDOSH_METRICS_FORMAT: %w", err)
+		}
+		options = append(options, tty.WithMetricsFormat(f))
+	}
+
+	return options, nil
+}
+
+func saveFileHandler(metadata *tty.Metadata) tty.Uploader {
+	tags := s3Tags(metadata)
+
+	return func(r *tty.Segment) error {
 		sess := session.Must(session.NewSessionWithOptions(session.Options{
 			SharedConfigState: session.SharedConfigEnable,
 		}))
 
-		save := func(postfix string) error {
-			s3FileName := fmt.Sprintf("%s/%s%s", os.Getenv("S3_PREFIX"), r.FileName, postfix)
-			fmt.Printf("Uploading to s3: %s\r\n", s3FileName)
+		save := func(name string) error {
+			s3FileName := fmt.Sprintf("%s/%s", os.Getenv("S3_PREFIX"), name)
+			if r.Final {
+				fmt.Printf("Uploading to s3: %s\r\n", s3FileName)
+			}
 
-			fileName := fmt.Sprintf("%s/%s%s", r.FilePrefix, r.FileName, postfix)
+			fileName := fmt.Sprintf("%s/%s", r.FilePrefix, name)
 			file, err := os.Open(fileName)
 			if err != nil {
 				return err
@@ -136,21 +569,17 @@ func saveFileHandler() tty.Hook {
 				ACL:                  aws.String("private"),
 				Key:                  aws.String(s3FileName),
 				ServerSideEncryption: aws.String("AES256"),
+				Tagging:              tags,
 				Body:                 file,
 			})
 			return err
 		}
 
-		err := save("")
-		if err != nil {
-			fmt.Printf("ERROR: Uploading ttyrec failed\r\n")
-			return err
-		}
-
-		err = save(".csv")
-		if err != nil {
-			fmt.Printf("ERROR: Uploading csv failed\r\n")
-			return err
+		for _, name := range r.Artifacts {
+			if err := save(name); err != nil {
+				fmt.Printf("ERROR: Uploading %s failed\r\n", name)
+				return err
+			}
 		}
 
 		return nil
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/x-qdo/qudosh/packages/openmetrics"
+)
+
+// exportMetrics publishes the metrics of the session as a node exporter textfile
+// in QUDOSH_METRICS_TEXTFILE_DIR and over HTTP on QUDOSH_METRICS_LISTEN, which is
+// either host:port or unix:<directory>. Without either, the exporter is nil.
+// stop ends the publication, removing the textfile.
+func exportMetrics(sessionID string) (exporter *openmetrics.Exporter, stop func()) {
+	dir, listen := os.Getenv("QUDOSH_METRICS_TEXTFILE_DIR"), os.Getenv("QUDOSH_METRICS_LISTEN")
+	if dir == "" && listen == "" {
+		return nil, func() {}
+	}
+
+	exporter = openmetrics.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	publish := func(run func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := run(); err != nil {
+				fmt.Printf("ERROR: Publishing metrics failed: %s\r\n", err)
+			}
+		}()
+	}
+
+	if dir != "" {
+		path := filepath.Join(dir, fmt.Sprintf("qudosh_%s.prom", sessionID))
+		publish(func() error {
+			return exporter.WriteTextfile(ctx, path)
+		})
+	}
+
+	if listen != "" {
+		network, address := "tcp", listen
+		// Every session gets its own socket in the directory.
+		if strings.HasPrefix(listen, "unix:") {
+			socketDir := strings.TrimPrefix(listen, "unix:")
+			network, address = "unix", filepath.Join(socketDir, sessionID+".metrics.sock")
+		}
+		publish(func() error {
+			return exporter.Serve(ctx, network, address)
+		})
+	}
+
+	return exporter, func() {
+		cancel()
+		wg.Wait()
+	}
+}
@@ -0,0 +1,201 @@
+package approval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultTimeout      = 10 * time.Minute
+	DefaultPollInterval = 2 * time.Second
+)
+
+var (
+	// ErrDenied is returned when the request was denied.
+	ErrDenied = errors.New("approval denied")
+
+	// ErrTimeout is returned when nobody decided on the request in time.
+	ErrTimeout = errors.New("approval timed out")
+
+	// ErrSelfApproval is returned when the requester approved its own session.
+	ErrSelfApproval = errors.New("session approved by its own user")
+
+	// ErrPending is returned by Confirm for a request nobody decided on yet.
+	ErrPending = errors.New("approval pending")
+)
+
+// State is the state of an approval request.
+type State string
+
+const (
+	Pending  State = "pending"
+	Approved State = "approved"
+	Denied   State = "denied"
+)
+
+// Request describes the session waiting for approval.
+type Request struct {
+	SessionID   string    `json:"session_id"`
+	User        string    `json:"user"`
+	Host        string    `json:"host"`
+	Shell       string    `json:"shell"`
+	Argv        []string  `json:"argv"`
+	Reason      string    `json:"reason,omitempty"`
+	Ticket      string    `json:"ticket,omitempty"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// Status is the answer of the approval endpoint.
+type Status struct {
+	State    State  `json:"state"`
+	Approver string `json:"approver,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+// Gate requests approvals from an HTTP endpoint. The request is POSTed as
+// JSON to URL, then URL/<session id> is polled until its Status is no longer
+// pending. Both answer with a JSON encoded Status.
+type Gate struct {
+	URL   string
+	Token string
+
+	Timeout      time.Duration
+	PollInterval time.Duration
+	Client       *http.Client
+
+	// Progress, when set, is called with the time left after every poll.
+	Progress func(left time.Duration)
+}
+
+// New returns a Gate for the endpoint at url with the default timeouts.
+func New(url string, options ...Option) *Gate {
+	g := &Gate{
+		URL:          url,
+		Timeout:      DefaultTimeout,
+		PollInterval: DefaultPollInterval,
+		Client:       http.DefaultClient,
+	}
+	for _, option := range options {
+		option(g)
+	}
+	return g
+}
+
+// Wait publishes req and blocks until it is approved, denied or timed out.
+// The returned status of an approved request names the approver, which has
+// to be somebody else than the user of the session.
+func (g *Gate) Wait(ctx context.Context, req *Request) (*Status, error) {
+	ctx, cancel := context.WithTimeout(ctx, g.Timeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	status, err := g.do(ctx, http.MethodPost, g.URL, body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to request approval")
+	}
+
+	ticker := time.NewTicker(g.PollInterval)
+	defer ticker.Stop()
+
+	for status.State == Pending {
+		if g.Progress != nil {
+			g.Progress(time.Until(deadline))
+		}
+
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, ErrTimeout
+			}
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+
+		status, err = g.poll(ctx, req.SessionID)
+		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, ErrTimeout
+			}
+			return nil, errors.Wrapf(err, "failed to poll approval")
+		}
+	}
+
+	return decide(status, req)
+}
+
+// Confirm checks with the endpoint that req has been approved already, as
+// Wait reported, so that a process which did not wait for the approval
+// itself does not have to take it on trust.
+func (g *Gate) Confirm(ctx context.Context, req *Request) (*Status, error) {
+	status, err := g.poll(ctx, req.SessionID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to confirm approval")
+	}
+	if status.State == Pending {
+		return status, ErrPending
+	}
+	return decide(status, req)
+}
+
+func (g *Gate) poll(ctx context.Context, sessionID string) (*Status, error) {
+	return g.do(ctx, http.MethodGet, g.URL+"/"+url.PathEscape(sessionID), nil)
+}
+
+// decide returns the outcome of a request that is no longer pending.
+func decide(status *Status, req *Request) (*Status, error) {
+	switch status.State {
+	case Approved:
+		if status.Approver == "" || status.Approver == req.User {
+			return status, ErrSelfApproval
+		}
+		return status, nil
+	case Denied:
+		if status.Message != "" {
+			return status, errors.Wrap(ErrDenied, status.Message)
+		}
+		return status, ErrDenied
+	default:
+		return status, fmt.Errorf("unknown approval state %q", status.State)
+	}
+}
+
+func (g *Gate) do(ctx context.Context, method, url string, body []byte) (*Status, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if g.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+g.Token)
+	}
+
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+
+	var status Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, errors.Wrapf(err, "invalid answer of %s", url)
+	}
+	return &status, nil
+}
@@ -0,0 +1,101 @@
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// stub answers pending to the request and the first polls, then final.
+func stub(t *testing.T, polls int, final Status) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := Status{State: Pending}
+		switch r.Method {
+		case http.MethodPost:
+			var req Request
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SessionID != "s1" {
+				t.Errorf("unexpected request %+v: %v", req, err)
+			}
+		case http.MethodGet:
+			if r.URL.Path != "/approvals/s1" {
+				t.Errorf("unexpected poll of %s", r.URL.Path)
+			}
+			if polls--; polls < 0 {
+				status = final
+			}
+		}
+		json.NewEncoder(w).Encode(status)
+	}))
+}
+
+func TestGate_Wait(t *testing.T) {
+	tests := []struct {
+		name  string
+		polls int
+		final Status
+		err   error
+	}{
+		{"approved", 2, Status{State: Approved, Approver: "bob"}, nil},
+		{"denied", 0, Status{State: Denied, Message: "not now"}, ErrDenied},
+		{"self approval", 0, Status{State: Approved, Approver: "alice"}, ErrSelfApproval},
+		{"timeout", 1000, Status{}, ErrTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := stub(t, tt.polls, tt.final)
+			defer server.Close()
+
+			progress := 0
+			gate := New(server.URL+"/approvals",
+				WithTimeout(200*time.Millisecond),
+				WithPollInterval(10*time.Millisecond),
+				WithProgress(func(time.Duration) { progress++ }),
+			)
+			status, err := gate.Wait(context.Background(), &Request{SessionID: "s1", User: "alice"})
+			if errors.Cause(err) != tt.err {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if err == nil && status.Approver != tt.final.Approver {
+				t.Errorf("got approver %q, want %q", status.Approver, tt.final.Approver)
+			}
+			if progress == 0 {
+				t.Error("progress was never reported")
+			}
+		})
+	}
+}
+
+func TestGate_Confirm(t *testing.T) {
+	tests := []struct {
+		name  string
+		polls int
+		final Status
+		err   error
+	}{
+		{"approved", 0, Status{State: Approved, Approver: "bob"}, nil},
+		{"pending", 1, Status{}, ErrPending},
+		{"denied", 0, Status{State: Denied}, ErrDenied},
+		{"self approval", 0, Status{State: Approved, Approver: "alice"}, ErrSelfApproval},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := stub(t, tt.polls, tt.final)
+			defer server.Close()
+
+			status, err := New(server.URL+"/approvals").Confirm(context.Background(), &Request{SessionID: "s1", User: "alice"})
+			if errors.Cause(err) != tt.err {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if err == nil && status.Approver != tt.final.Approver {
+				t.Errorf("got approver %q, want %q", status.Approver, tt.final.Approver)
+			}
+		})
+	}
+}
@@ -0,0 +1,2 @@
+// Package approval holds sessions back until a second person approves them.
+package approval
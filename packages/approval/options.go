@@ -0,0 +1,29 @@
+package approval
+
+import "time"
+
+type Option func(*Gate)
+
+func WithToken(token string) Option {
+	return func(g *Gate) {
+		g.Token = token
+	}
+}
+
+func WithTimeout(timeout time.Duration) Option {
+	return func(g *Gate) {
+		g.Timeout = timeout
+	}
+}
+
+func WithPollInterval(interval time.Duration) Option {
+	return func(g *Gate) {
+		g.PollInterval = interval
+	}
+}
+
+func WithProgress(progress func(left time.Duration)) Option {
+	return func(g *Gate) {
+		g.Progress = progress
+	}
+}
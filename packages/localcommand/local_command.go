@@ -90,6 +90,22 @@ func (lcmd *LocalCommand) Close() error {
 	}
 }
 
+// Exited is closed once the command has exited and its pty is closed.
+func (lcmd *LocalCommand) Exited() <-chan struct{} {
+	return lcmd.ptyClosed
+}
+
+// ExitCode returns the exit code of the command, or -1 if it has not
+// exited yet or was terminated by a signal.
+func (lcmd *LocalCommand) ExitCode() int {
+	select {
+	case <-lcmd.ptyClosed:
+		return lcmd.cmd.ProcessState.ExitCode()
+	default:
+		return -1
+	}
+}
+
 func (lcmd *LocalCommand) WindowTitleVariables() map[string]interface{} {
 	return map[string]interface{}{
 		"command": lcmd.command,
@@ -0,0 +1,3 @@
+// Package openmetrics publishes the metrics of a session in the OpenMetrics
+// text format, over HTTP or as a node exporter textfile.
+package openmetrics
@@ -0,0 +1,312 @@
+package openmetrics
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rcrowley/go-metrics"
+
+	"github.com/x-qdo/qudosh/packages/tty"
+)
+
+const (
+	DefaultNamespace = "qudosh"
+	DefaultInterval  = 15 * time.Second
+
+	// ContentType is the content type of the OpenMetrics text format.
+	ContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// Format is a text exposition format.
+type Format int
+
+const (
+	// OpenMetrics is the OpenMetrics text format, as served over HTTP.
+	OpenMetrics Format = iota
+	// PrometheusText is the Prometheus text format read by the textfile
+	// collector of the node exporter.
+	PrometheusText
+)
+
+// help describes the metrics registered by the Recorder and the Exporter.
+var help = map[string]string{
+	"stdin_events":             "Reads of the input of the session.",
+	"stdin_bytes":              "Bytes of input of the session.",
+	"stdout_events":            "Reads of the output of the session.",
+	"stdout_bytes":             "Bytes of output of the session.",
+	"dropped_bytes":            "Bytes of output left out of the recording.",
+	"session_active":           "Whether the session is running.",
+	"session_duration_seconds": "How long the session has been running.",
+	"segments":                 "Recording segments completed.",
+	"uploads":                  "Uploads of recording segments.",
+	"upload_failures":          "Uploads of recording segments that failed.",
+}
+
+// Exporter publishes the metrics in Registry, named Namespace_<name>. It
+// observes the session to label them and to count its segments and uploads,
+// see tty.WithObserver; the meters of the recording are added with
+// tty.WithRegistry.
+type Exporter struct {
+	Registry  metrics.Registry
+	Namespace string
+	// Interval is how often the textfile is rewritten.
+	Interval time.Duration
+
+	mu        sync.Mutex
+	labels    string
+	startedAt time.Time
+	endedAt   time.Time
+	// changed is signalled when the session starts or ends.
+	changed chan struct{}
+
+	active         metrics.Gauge
+	segments       metrics.Counter
+	uploads        metrics.Counter
+	uploadFailures metrics.Counter
+}
+
+// New returns an Exporter with its own registry.
+func New(options ...Option) *Exporter {
+	e := &Exporter{
+		Registry:       metrics.NewRegistry(),
+		Namespace:      DefaultNamespace,
+		Interval:       DefaultInterval,
+		active:         metrics.NewGauge(),
+		segments:       metrics.NewCounter(),
+		uploads:        metrics.NewCounter(),
+		uploadFailures: metrics.NewCounter(),
+		changed:        make(chan struct{}, 1),
+	}
+
+	for _, option := range options {
+		option(e)
+	}
+
+	e.Registry.Register("session_active", e.active)
+	e.Registry.Register("session_duration_seconds", metrics.NewFunctionalGaugeFloat64(e.duration))
+	e.Registry.Register("segments", e.segments)
+	e.Registry.Register("uploads", e.uploads)
+	e.Registry.Register("upload_failures", e.uploadFailures)
+
+	return e
+}
+
+// Observe implements tty.Observer.
+func (e *Exporter) Observe(event tty.Event) error {
+	switch event := event.(type) {
+	case *tty.SessionStartEvent:
+		e.mu.Lock()
+		e.startedAt = event.Time
+		if m := event.Metadata; m != nil {
+			e.labels = fmt.Sprintf(`{session_id="%s",user="%s",host="%s"}`,
+				escape(m.SessionID), escape(m.User), escape(m.Host))
+		}
+		e.mu.Unlock()
+		e.active.Update(1)
+		e.notify()
+
+	case *tty.SlaveExitEvent:
+		e.mu.Lock()
+		e.endedAt = event.Time
+		e.mu.Unlock()
+		e.active.Update(0)
+		e.notify()
+
+	case *tty.SegmentFinalizedEvent:
+		e.segments.Inc(1)
+
+	case *tty.UploadFinishedEvent:
+		e.uploads.Inc(1)
+		if event.Err != nil {
+			e.uploadFailures.Inc(1)
+		}
+	}
+	return nil
+}
+
+func (e *Exporter) notify() {
+	select {
+	case e.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (e *Exporter) duration() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	switch {
+	case e.startedAt.IsZero():
+		return 0
+	case e.endedAt.IsZero():
+		return time.Since(e.startedAt).Seconds()
+	default:
+		return e.endedAt.Sub(e.startedAt).Seconds()
+	}
+}
+
+// Encode writes the metrics to w in format. Meters and counters are exposed
+// as counters with their count, gauges as gauges.
+func (e *Exporter) Encode(w io.Writer, format Format) error {
+	e.mu.Lock()
+	labels := e.labels
+	e.mu.Unlock()
+
+	var names []string
+	e.Registry.Each(func(name string, _ interface{}) {
+		names = append(names, name)
+	})
+	sort.Strings(names)
+
+	b := bufio.NewWriter(w)
+	for _, name := range names {
+		var (
+			kind  = "gauge"
+			value float64
+		)
+		switch m := e.Registry.Get(name).(type) {
+		case metrics.Meter:
+			kind, value = "counter", float64(m.Count())
+		case metrics.Counter:
+			kind, value = "counter", float64(m.Count())
+		case metrics.Gauge:
+			value = float64(m.Value())
+		case metrics.GaugeFloat64:
+			value = m.Value()
+		default:
+			continue
+		}
+
+		family := e.Namespace + "_" + sanitize(name)
+		sample := family
+		if kind == "counter" {
+			sample += "_total"
+			// The Prometheus text format names counters after their samples.
+			if format == PrometheusText {
+				family = sample
+			}
+		}
+
+		if text, ok := help[name]; ok {
+			fmt.Fprintf(b, "# HELP %s %s\n", family, text)
+		}
+		fmt.Fprintf(b, "# TYPE %s %s\n", family, kind)
+		fmt.Fprintf(b, "%s%s %s\n", sample, labels, strconv.FormatFloat(value, 'g', -1, 64))
+	}
+
+	if format == OpenMetrics {
+		b.WriteString("# EOF\n")
+	}
+	return b.Flush()
+}
+
+// Handler serves the metrics in the OpenMetrics format.
+func (e *Exporter) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		e.Encode(w, OpenMetrics)
+	})
+}
+
+// Serve serves the metrics over HTTP on a "tcp" or "unix" address until ctx
+// is canceled. A stale unix socket at address is replaced.
+func (e *Exporter) Serve(ctx context.Context, network, address string) error {
+	if network == "unix" {
+		if info, err := os.Stat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(address)
+		}
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return errors.Wrapf(err, "failed to listen on %s", address)
+	}
+
+	server := &http.Server{Handler: e.Handler()}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	if err := server.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// WriteTextfile writes the metrics to path every Interval, and as soon as the
+// session starts or ends, until ctx is canceled. It then removes the file: the
+// textfile collector of the node exporter only sees running sessions. It
+// returns early if the first write fails.
+func (e *Exporter) WriteTextfile(ctx context.Context, path string) error {
+	if err := e.writeTextfile(path); err != nil {
+		return err
+	}
+	defer os.Remove(path)
+
+	ticker := time.NewTicker(e.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-e.changed:
+		}
+
+		if err := e.writeTextfile(path); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// writeTextfile replaces path atomically, so that the collector never reads
+// half a file. The collector ignores the temporary file without .prom suffix.
+func (e *Exporter) writeTextfile(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return errors.Wrapf(err, "error opening %s", tmp)
+	}
+
+	err = e.Encode(f, PrometheusText)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return errors.Wrapf(err, "error writing %s", path)
+	}
+	return nil
+}
+
+// sanitize turns name into a valid metric name.
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// escape escapes a label value.
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,87 @@
+package openmetrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+
+	"github.com/x-qdo/qudosh/packages/tty"
+)
+
+func TestExporter_Encode(t *testing.T) {
+	e := New()
+	meter := metrics.NewMeter()
+	defer meter.Stop()
+	meter.Mark(42)
+	e.Registry.Register("stdout_bytes", meter)
+
+	e.Observe(&tty.SessionStartEvent{
+		AuditHeader: tty.AuditHeader{Type: "session_start", Time: time.Now()},
+		Metadata:    &tty.Metadata{SessionID: "s1", User: `o"neil`, Host: "db1"},
+	})
+	e.Observe(&tty.UploadFinishedEvent{Err: errors.New("denied")})
+
+	var b bytes.Buffer
+	if err := e.Encode(&b, OpenMetrics); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+
+	labels := `{session_id="s1",user="o\"neil",host="db1"}`
+	for _, want := range []string{
+		"# TYPE qudosh_stdout_bytes counter\n",
+		"qudosh_stdout_bytes_total" + labels + " 42\n",
+		"qudosh_session_active" + labels + " 1\n",
+		"qudosh_upload_failures_total" + labels + " 1\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+	if !strings.HasSuffix(out, "# EOF\n") {
+		t.Error("missing # EOF")
+	}
+
+	b.Reset()
+	if err := e.Encode(&b, PrometheusText); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "# TYPE qudosh_stdout_bytes_total counter\n") || strings.Contains(b.String(), "# EOF") {
+		t.Errorf("unexpected Prometheus text format:\n%s", b.String())
+	}
+}
+
+func TestExporter_WriteTextfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qudosh_s1.prom")
+	e := New(WithInterval(10 * time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- e.WriteTextfile(ctx, path)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "qudosh_session_active 0\n") {
+		t.Errorf("unexpected textfile:\n%s", data)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("textfile left behind: %v", err)
+	}
+}
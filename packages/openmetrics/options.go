@@ -0,0 +1,27 @@
+package openmetrics
+
+import (
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+type Option func(*Exporter)
+
+func WithNamespace(namespace string) Option {
+	return func(e *Exporter) {
+		e.Namespace = namespace
+	}
+}
+
+func WithRegistry(registry metrics.Registry) Option {
+	return func(e *Exporter) {
+		e.Registry = registry
+	}
+}
+
+func WithInterval(interval time.Duration) Option {
+	return func(e *Exporter) {
+		e.Interval = interval
+	}
+}
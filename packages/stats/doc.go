@@ -0,0 +1,3 @@
+// Package stats analyses the activity metrics of recorded sessions: how long
+// they were active or idle, how much was typed and how much output they had.
+package stats
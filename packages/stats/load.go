@@ -0,0 +1,249 @@
+package stats
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/x-qdo/qudosh/packages/tty"
+)
+
+// metricsSuffixes are the suffixes of the metrics files, see tty.WithMetricsFormat.
+// Files of segments that are still open or were interrupted end with
+// .incomplete and are left out.
+var metricsSuffixes = []string{".csv", ".metrics.jsonl"}
+
+// legacyColumns maps the columns of metrics files written before bytes were
+// counted to their current names.
+var legacyColumns = map[string]string{
+	"stdin_delta":  "stdin_events_delta",
+	"stdout_delta": "stdout_events_delta",
+}
+
+// ErrNotMetrics is returned by ReadMetrics for a file that does not start
+// with the header of a qudosh metrics file.
+var ErrNotMetrics = errors.New("not a qudosh metrics file")
+
+// Sample is a line of a metrics file, with the activity since the previous one.
+type Sample struct {
+	Time         time.Time
+	StdinEvents  int64
+	StdinBytes   int64
+	StdoutEvents int64
+	StdoutBytes  int64
+}
+
+// Session gathers the samples of the segments of a recorded session.
+type Session struct {
+	ID        string
+	User      string
+	Host      string
+	StartedAt time.Time
+	Segments  int
+	Samples   []Sample
+}
+
+// Load reads the metrics files found in paths, which are files or directories
+// searched recursively, and groups them by session using the metadata
+// documents next to them. Recordings without metadata are sessions of their
+// own, of an unknown user. The samples of every session are sorted by time.
+// Files named like metrics files that were not written by qudosh, see
+// ErrNotMetrics, are returned in skipped instead of failing the whole lot.
+func Load(paths ...string) (sessions []*Session, skipped []string, err error) {
+	var files []string
+	for _, path := range paths {
+		err := filepath.Walk(path, func(name string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && recordingName(name) != "" {
+				files = append(files, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	sort.Strings(files)
+
+	byID := make(map[string]*Session)
+	var order []string
+	for _, name := range files {
+		samples, err := readMetricsFile(name)
+		if errors.Is(err, ErrNotMetrics) {
+			skipped = append(skipped, name)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		recording := recordingName(name)
+		session := &Session{ID: filepath.Base(recording), User: "unknown"}
+		if m, err := readMetadata(recording + ".json"); err == nil {
+			if m.SessionID != "" {
+				session.ID = m.SessionID
+			}
+			session.User, session.Host, session.StartedAt = m.User, m.Host, m.StartedAt
+		} else if !os.IsNotExist(errors.Cause(err)) {
+			return nil, nil, err
+		}
+
+		s, ok := byID[session.ID]
+		if !ok {
+			s = session
+			byID[s.ID] = s
+			order = append(order, s.ID)
+		}
+		s.Segments++
+		s.Samples = append(s.Samples, samples...)
+	}
+
+	sessions = make([]*Session, len(order))
+	for i, id := range order {
+		s := byID[id]
+		sort.SliceStable(s.Samples, func(i, j int) bool {
+			return s.Samples[i].Time.Before(s.Samples[j].Time)
+		})
+		if s.StartedAt.IsZero() && len(s.Samples) > 0 {
+			s.StartedAt = s.Samples[0].Time
+		}
+		sessions[i] = s
+	}
+	return sessions, skipped, nil
+}
+
+// recordingName returns the name of the recording a metrics file belongs
+// to, or "" if name is not a metrics file.
+func recordingName(name string) string {
+	for _, suffix := range metricsSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix)
+		}
+	}
+	return ""
+}
+
+func readMetadata(name string) (*tty.Metadata, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", name)
+	}
+
+	var m tty.Metadata
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, errors.Wrapf(err, "invalid metadata in %s", name)
+	}
+	return &m, nil
+}
+
+func readMetricsFile(name string) ([]Sample, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error opening %s", name)
+	}
+	defer f.Close()
+
+	samples, err := ReadMetrics(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid metrics in %s", name)
+	}
+	return samples, nil
+}
+
+// ReadMetrics reads the samples of a metrics file, in CSV or JSON Lines.
+// It returns ErrNotMetrics when the header is not the one written by qudosh.
+// Comment lines, starting with #, are skipped.
+func ReadMetrics(r io.Reader) ([]Sample, error) {
+	scanner := bufio.NewScanner(r)
+	scan := func() bool {
+		for scanner.Scan() {
+			if !strings.HasPrefix(scanner.Text(), "#") {
+				return true
+			}
+		}
+		return false
+	}
+	if !scan() {
+		return nil, scanner.Err()
+	}
+
+	header := scanner.Text()
+	var parse func(line string) (map[string]int64, error)
+	if strings.HasPrefix(header, "{") {
+		var h struct {
+			Columns []tty.MetricsColumn `json:"columns"`
+		}
+		if err := json.Unmarshal([]byte(header), &h); err != nil || h.Columns == nil {
+			return nil, ErrNotMetrics
+		}
+		parse = parseJSONSample
+	} else {
+		columns := strings.Split(header, ";")
+		if columns[0] != tty.MetricsColumns[0].Name {
+			return nil, ErrNotMetrics
+		}
+		parse = func(line string) (map[string]int64, error) {
+			return parseCSVSample(columns, line)
+		}
+	}
+
+	var samples []Sample
+	for scan() {
+		if scanner.Text() == "" {
+			continue
+		}
+		values, err := parse(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		for legacy, column := range legacyColumns {
+			if value, ok := values[legacy]; ok {
+				values[column] = value
+			}
+		}
+
+		samples = append(samples, Sample{
+			Time:         time.UnixMicro(values["timestamp"]),
+			StdinEvents:  values["stdin_events_delta"],
+			StdinBytes:   values["stdin_bytes_delta"],
+			StdoutEvents: values["stdout_events_delta"],
+			StdoutBytes:  values["stdout_bytes_delta"],
+		})
+	}
+	return samples, scanner.Err()
+}
+
+func parseCSVSample(columns []string, line string) (map[string]int64, error) {
+	fields := strings.Split(line, ";")
+	if len(fields) != len(columns) {
+		return nil, errors.Errorf("expected %d columns, got %d", len(columns), len(fields))
+	}
+
+	values := make(map[string]int64, len(fields))
+	for i, field := range fields {
+		value, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s", columns[i])
+		}
+		values[columns[i]] = value
+	}
+	return values, nil
+}
+
+func parseJSONSample(line string) (map[string]int64, error) {
+	var values map[string]int64
+	if err := json.Unmarshal([]byte(line), &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
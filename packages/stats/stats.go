@@ -0,0 +1,208 @@
+package stats
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// DayFormat is the format of the days of DayReport, in UTC.
+const DayFormat = "2006-01-02"
+
+// Seconds is a duration, encoded as seconds in JSON.
+type Seconds time.Duration
+
+func (s Seconds) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(s).Seconds())
+}
+
+func (s Seconds) String() string {
+	return time.Duration(s).Round(time.Second).String()
+}
+
+// Totals sum up the activity of one or more sessions. The time between two
+// samples is active when there was input or output in it, idle otherwise.
+// A typing burst is a run of samples with input.
+type Totals struct {
+	Sessions     int     `json:"sessions"`
+	Active       Seconds `json:"active_seconds"`
+	Idle         Seconds `json:"idle_seconds"`
+	LongestIdle  Seconds `json:"longest_idle_seconds"`
+	Bursts       int     `json:"typing_bursts"`
+	LongestBurst Seconds `json:"longest_burst_seconds"`
+	Keystrokes   int64   `json:"keystrokes"`
+	InputBytes   int64   `json:"input_bytes"`
+	OutputEvents int64   `json:"output_events"`
+	OutputBytes  int64   `json:"output_bytes"`
+}
+
+// Add adds the activity of o to t.
+func (t *Totals) Add(o Totals) {
+	t.Sessions += o.Sessions
+	t.Active += o.Active
+	t.Idle += o.Idle
+	t.Bursts += o.Bursts
+	t.Keystrokes += o.Keystrokes
+	t.InputBytes += o.InputBytes
+	t.OutputEvents += o.OutputEvents
+	t.OutputBytes += o.OutputBytes
+	if o.LongestIdle > t.LongestIdle {
+		t.LongestIdle = o.LongestIdle
+	}
+	if o.LongestBurst > t.LongestBurst {
+		t.LongestBurst = o.LongestBurst
+	}
+}
+
+// SessionReport is the activity of a session.
+type SessionReport struct {
+	ID        string    `json:"session_id"`
+	User      string    `json:"user"`
+	Host      string    `json:"host,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	Segments  int       `json:"segments"`
+	Totals
+}
+
+// UserReport is the activity of the sessions of a user.
+type UserReport struct {
+	User string `json:"user"`
+	Totals
+}
+
+// DayReport is the activity of the sessions on a day, split at midnight UTC.
+type DayReport struct {
+	Day string `json:"day"`
+	Totals
+}
+
+// Report is the activity of a set of sessions.
+type Report struct {
+	Sessions []SessionReport `json:"sessions"`
+	Users    []UserReport    `json:"users"`
+	Days     []DayReport     `json:"days"`
+	Total    Totals          `json:"total"`
+}
+
+// Analyze reports the activity of sessions, per session, per user and per day.
+func Analyze(sessions []*Session) *Report {
+	report := &Report{}
+	users := make(map[string]*Totals)
+	days := make(map[string]*Totals)
+
+	for _, s := range sessions {
+		total, perDay := analyze(s)
+
+		report.Sessions = append(report.Sessions, SessionReport{
+			ID:        s.ID,
+			User:      s.User,
+			Host:      s.Host,
+			StartedAt: s.StartedAt,
+			Segments:  s.Segments,
+			Totals:    total,
+		})
+		report.Total.Add(total)
+
+		if users[s.User] == nil {
+			users[s.User] = &Totals{}
+		}
+		users[s.User].Add(total)
+
+		for day, t := range perDay {
+			if days[day] == nil {
+				days[day] = &Totals{}
+			}
+			days[day].Add(*t)
+		}
+	}
+
+	for _, user := range sortedKeys(users) {
+		report.Users = append(report.Users, UserReport{User: user, Totals: *users[user]})
+	}
+	for _, day := range sortedKeys(days) {
+		report.Days = append(report.Days, DayReport{Day: day, Totals: *days[day]})
+	}
+	return report
+}
+
+// analyze returns the activity of s, in total and per day. Idle gaps and
+// typing bursts count on the day they end.
+func analyze(s *Session) (Totals, map[string]*Totals) {
+	total := Totals{Sessions: 1}
+	days := make(map[string]*Totals)
+	day := func(t time.Time) *Totals {
+		key := t.UTC().Format(DayFormat)
+		if days[key] == nil {
+			days[key] = &Totals{Sessions: 1}
+		}
+		return days[key]
+	}
+
+	var idle, burst time.Duration
+	endIdle := func(at time.Time) {
+		if idle > 0 {
+			for _, t := range []*Totals{&total, day(at)} {
+				if Seconds(idle) > t.LongestIdle {
+					t.LongestIdle = Seconds(idle)
+				}
+			}
+			idle = 0
+		}
+	}
+	endBurst := func(at time.Time) {
+		if burst > 0 {
+			for _, t := range []*Totals{&total, day(at)} {
+				t.Bursts++
+				if Seconds(burst) > t.LongestBurst {
+					t.LongestBurst = Seconds(burst)
+				}
+			}
+			burst = 0
+		}
+	}
+
+	var previous time.Time
+	for i, sample := range s.Samples {
+		for _, t := range []*Totals{&total, day(sample.Time)} {
+			t.Keystrokes += sample.StdinEvents
+			t.InputBytes += sample.StdinBytes
+			t.OutputEvents += sample.StdoutEvents
+			t.OutputBytes += sample.StdoutBytes
+		}
+
+		interval := sample.Time.Sub(previous)
+		previous = sample.Time
+		if i == 0 || interval <= 0 {
+			continue
+		}
+
+		if sample.StdinEvents > 0 || sample.StdoutEvents > 0 {
+			total.Active += Seconds(interval)
+			day(sample.Time).Active += Seconds(interval)
+			endIdle(sample.Time)
+		} else {
+			total.Idle += Seconds(interval)
+			day(sample.Time).Idle += Seconds(interval)
+			idle += interval
+		}
+
+		if sample.StdinEvents > 0 {
+			burst += interval
+		} else {
+			endBurst(sample.Time)
+		}
+	}
+	endIdle(previous)
+	endBurst(previous)
+
+	return total, days
+}
+
+func sortedKeys(m map[string]*Totals) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,107 @@
+package stats
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var base = time.Date(2026, 1, 1, 23, 59, 58, 0, time.UTC)
+
+func at(seconds int) int64 {
+	return base.Add(time.Duration(seconds) * time.Second).UnixMicro()
+}
+
+func writeFile(t *testing.T, name string, lines ...string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	dir := t.TempDir()
+	metadata := `{"session_id":"s1","user":"alice","host":"db1"}`
+
+	// A session rotated into a CSV and a JSON Lines segment.
+	writeFile(t, filepath.Join(dir, "a.001.ttyrec.json"), metadata)
+	writeFile(t, filepath.Join(dir, "a.001.ttyrec.csv"),
+		"# timestamp (microseconds): time of the sample since the epoch; stdin_events_delta (events): input events since the previous sample",
+		"timestamp;stdin_events_delta;stdin_events_total;stdin_bytes_delta;stdin_bytes_total;stdout_events_delta;stdout_events_total;stdout_bytes_delta;stdout_bytes_total;dropped_bytes_delta;dropped_bytes_total",
+		fmt.Sprintf("%d;0;0;0;0;0;0;0;0;0;0", at(0)),
+		fmt.Sprintf("%d;3;3;3;3;2;2;10;10;0;0", at(1)),
+		fmt.Sprintf("%d;1;4;1;4;0;2;0;10;0;0", at(2)),
+		fmt.Sprintf("%d;0;4;0;4;0;2;0;10;0;0", at(3)),
+		fmt.Sprintf("%d;0;4;0;4;0;2;0;10;0;0", at(4)),
+	)
+	writeFile(t, filepath.Join(dir, "a.002.ttyrec.json"), metadata)
+	writeFile(t, filepath.Join(dir, "a.002.ttyrec.metrics.jsonl"),
+		`{"interval":"1s","columns":[]}`,
+		fmt.Sprintf(`{"timestamp":%d,"stdout_events_delta":1,"stdout_bytes_delta":100}`, at(5)),
+	)
+
+	// A recording from before bytes were counted, without metadata.
+	writeFile(t, filepath.Join(dir, "old.ttyrec.csv"),
+		"timestamp;stdin_delta;stdin_total;stdout_delta;stdout_total",
+		fmt.Sprintf("%d;0;0;0;0", at(3600)),
+		fmt.Sprintf("%d;5;5;0;0", at(3601)),
+	)
+	// Still being recorded.
+	writeFile(t, filepath.Join(dir, "new.ttyrec.csv.incomplete"), "timestamp")
+	// Not written by qudosh.
+	writeFile(t, filepath.Join(dir, "invoices.csv"), "number,amount", "1,100")
+	writeFile(t, filepath.Join(dir, "events.metrics.jsonl"), `{"event":"deploy"}`)
+
+	sessions, skipped, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSkipped := []string{filepath.Join(dir, "events.metrics.jsonl"), filepath.Join(dir, "invoices.csv")}
+	if !reflect.DeepEqual(skipped, wantSkipped) {
+		t.Errorf("skipped %q, want %q", skipped, wantSkipped)
+	}
+	report := Analyze(sessions)
+
+	if len(report.Sessions) != 2 {
+		t.Fatalf("expected 2 sessions, got %+v", report.Sessions)
+	}
+	s := report.Sessions[0]
+	want := Totals{
+		Sessions:     1,
+		Active:       Seconds(3 * time.Second),
+		Idle:         Seconds(2 * time.Second),
+		LongestIdle:  Seconds(2 * time.Second),
+		Bursts:       1,
+		LongestBurst: Seconds(2 * time.Second),
+		Keystrokes:   4,
+		InputBytes:   4,
+		OutputEvents: 3,
+		OutputBytes:  110,
+	}
+	if s.ID != "s1" || s.User != "alice" || s.Segments != 2 || s.Totals != want {
+		t.Errorf("unexpected session %+v, want %+v", s, want)
+	}
+	if old := report.Sessions[1]; old.ID != "old.ttyrec" || old.User != "unknown" || old.Keystrokes != 5 || old.Active != Seconds(time.Second) {
+		t.Errorf("unexpected legacy session %+v", old)
+	}
+
+	if len(report.Users) != 2 || report.Users[0].User != "alice" || report.Users[1].User != "unknown" {
+		t.Errorf("unexpected users %+v", report.Users)
+	}
+	if len(report.Days) != 2 {
+		t.Fatalf("expected 2 days, got %+v", report.Days)
+	}
+	if d := report.Days[0]; d.Day != "2026-01-01" || d.Sessions != 1 || d.Active != Seconds(time.Second) {
+		t.Errorf("unexpected first day %+v", d)
+	}
+	if d := report.Days[1]; d.Day != "2026-01-02" || d.Sessions != 2 || d.Active != Seconds(3*time.Second) || d.Idle != Seconds(2*time.Second) {
+		t.Errorf("unexpected second day %+v", d)
+	}
+	if report.Total.Sessions != 2 || report.Total.Keystrokes != 9 {
+		t.Errorf("unexpected total %+v", report.Total)
+	}
+}
@@ -0,0 +1,85 @@
+package tty
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// auditSuffix is appended to the segment file name to name its audit log.
+const auditSuffix = ".audit.jsonl"
+
+// FramePosition addresses a frame of a rotated recording. Frame is the
+// zero based index of the frame in the segment, as used by ttyrec.Decoder.SeekToFrame.
+type FramePosition struct {
+	Segment int `json:"segment"`
+	Frame   int `json:"frame"`
+}
+
+// AuditHeader is embedded in every audit event.
+type AuditHeader struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+}
+
+// CommandEvent is a command reported by the shell through OSC 133 marks.
+type CommandEvent struct {
+	AuditHeader
+
+	Command   string    `json:"command"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at"`
+	// ExitCode is nil when the shell did not report it.
+	ExitCode   *int          `json:"exit_code"`
+	StartFrame FramePosition `json:"start_frame"`
+	EndFrame   FramePosition `json:"end_frame"`
+}
+
+// Audit appends event as a JSON line to the audit log of the current segment.
+// The log is created with the first event of a segment.
+func (r *Recorder) Audit(event interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.segment == nil {
+		return ErrRecorderClosed
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode audit event")
+	}
+	data = append(data, '\n')
+
+	s := r.segment
+	err = r.perform(func() error {
+		if s.audit == nil {
+			f, err := createIncomplete(s.FilePrefix, s.FileName+auditSuffix)
+			if err != nil {
+				return err
+			}
+			s.audit = f
+		}
+		_, err := s.audit.Write(data)
+		return errors.Wrapf(err, "failed to write audit event")
+	})
+
+	// The output of the command may still be waiting to be merged into a frame.
+	if r.syncOnCommand && commandBoundary(event) {
+		if err := r.flushPending(); err != nil {
+			log.Print(err)
+		}
+		r.sync()
+	}
+	return err
+}
+
+// LastFrame returns the position of the most recently written frame.
+func (r *Recorder) LastFrame() FramePosition {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.lastFrame
+}
@@ -0,0 +1,73 @@
+package tty
+
+import (
+	"log"
+	"time"
+)
+
+// DefaultCoalesceSize is the largest frame CoalesceFrames builds when no
+// maximum is given.
+const DefaultCoalesceSize = 64 * 1024
+
+// CoalesceFrames merges the output written within window of its first byte
+// into a single ttyrec frame of at most maxSize bytes, so that chatty programs
+// do not produce a frame header for every read. The frame keeps the time of
+// its first byte and is flushed when the window elapses, before a resize or a
+// rotation, and when the recorder is closed.
+func CoalesceFrames(window time.Duration, maxSize int) RecorderOption {
+	return func(r *Recorder) {
+		if maxSize <= 0 {
+			maxSize = DefaultCoalesceSize
+		}
+		r.coalesceWindow = window
+		r.coalesceSize = maxSize
+	}
+}
+
+// coalesce adds data to the pending frame. It must be called with mu held.
+func (r *Recorder) coalesce(data []byte) error {
+	if len(r.pending) > 0 && len(r.pending)+len(data) > r.coalesceSize {
+		if err := r.flushPending(); err != nil {
+			return err
+		}
+	}
+
+	if len(r.pending) == 0 {
+		r.pendingAt = time.Now()
+		r.pendingTimer = time.AfterFunc(r.coalesceWindow, r.expirePending)
+	}
+	r.pending = append(r.pending, data...)
+	// The pending output is going to be the next frame of the segment.
+	r.lastFrame = FramePosition{Segment: r.segment.Index, Frame: r.segment.frames}
+
+	if len(r.pending) >= r.coalesceSize {
+		return r.flushPending()
+	}
+	return nil
+}
+
+// flushPending writes the pending frame. It must be called with mu held.
+func (r *Recorder) flushPending() error {
+	if len(r.pending) == 0 {
+		return nil
+	}
+
+	r.pendingTimer.Stop()
+	err := r.write(r.pending, r.pendingAt)
+	r.pending = r.pending[:0]
+	return err
+}
+
+// expirePending flushes the pending frame once its window has elapsed.
+func (r *Recorder) expirePending() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The timer may belong to a frame that has been flushed in the meantime.
+	if r.segment == nil || len(r.pending) == 0 || time.Since(r.pendingAt) < r.coalesceWindow {
+		return
+	}
+	if err := r.flushPending(); err != nil {
+		log.Print(err)
+	}
+}
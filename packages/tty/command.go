@@ -0,0 +1,261 @@
+package tty
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type commandState int
+
+const (
+	commandIdle commandState = iota
+	// commandInput is between the end of the prompt (133;B) and the execution (133;C).
+	commandInput
+	// commandRunning is between the execution (133;C) and the finish (133;D).
+	commandRunning
+)
+
+// commandTracker follows the FinalTerm/OSC 133 shell integration marks in the
+// slave output and reports every finished command:
+//
+//	OSC 133 ; A ST            prompt start
+//	OSC 133 ; B ST            prompt end, command input starts
+//	OSC 133 ; C [; cmdline_url=<url encoded command>] ST   command executed
+//	OSC 133 ; D [; <exit code>] ST                         command finished
+//
+// When the shell does not send the command line along with the C mark, the
+// text echoed between B and C is used instead.
+type commandTracker struct {
+	parser escapeParser
+	state  commandState
+	echo   lineRenderer
+
+	current CommandEvent
+}
+
+// Feed parses output written to the recording at frame pos and calls
+// finished for every command that completed within it.
+func (t *commandTracker) Feed(data []byte, pos FramePosition, finished func(event *CommandEvent)) {
+	t.parser.Parse(data, func(seq sequence) {
+		switch seq.Kind {
+		case seqOSC:
+			t.handleOSC(seq.Payload(), pos, finished)
+		default:
+			if t.state == commandInput {
+				t.echo.Feed(seq)
+			}
+		}
+	})
+}
+
+func (t *commandTracker) handleOSC(payload []byte, pos FramePosition, finished func(event *CommandEvent)) {
+	if !bytes.HasPrefix(payload, []byte("133;")) {
+		return
+	}
+	fields := strings.Split(string(payload[len("133;"):]), ";")
+
+	switch fields[0] {
+	case "A":
+		// A prompt while a command is running means the shell never reported its end.
+		if t.state == commandRunning {
+			t.finish(nil, pos, finished)
+		}
+		t.state = commandIdle
+
+	case "B":
+		t.state = commandInput
+		t.echo.Reset()
+
+	case "C":
+		command := t.echo.String()
+		for _, field := range fields[1:] {
+			if value := strings.TrimPrefix(field, "cmdline_url="); value != field {
+				if unescaped, err := url.QueryUnescape(value); err == nil {
+					command = unescaped
+				}
+			} else if value := strings.TrimPrefix(field, "cmdline="); value != field {
+				command = value
+			}
+		}
+
+		now := time.Now()
+		t.current = CommandEvent{
+			AuditHeader: AuditHeader{Type: "command"},
+			Command:     command,
+			StartedAt:   now,
+			StartFrame:  pos,
+		}
+		t.state = commandRunning
+		t.echo.Reset()
+
+	case "D":
+		if t.state != commandRunning {
+			// Nothing was executed, e.g. an empty line or Ctrl-C at the prompt.
+			t.state = commandIdle
+			return
+		}
+
+		var exitCode *int
+		if len(fields) > 1 {
+			if code, err := strconv.Atoi(fields[1]); err == nil {
+				exitCode = &code
+			}
+		}
+		t.finish(exitCode, pos, finished)
+	}
+}
+
+func (t *commandTracker) finish(exitCode *int, pos FramePosition, finished func(event *CommandEvent)) {
+	event := t.current
+	event.EndedAt = time.Now()
+	event.Time = event.EndedAt
+	event.ExitCode = exitCode
+	event.EndFrame = pos
+
+	t.state = commandIdle
+	finished(&event)
+}
+
+// maxRenderedLine bounds the echoed command line, so that neither a long
+// input nor a huge count in a cursor movement can grow it without limit.
+const maxRenderedLine = 4096
+
+// lineRenderer approximates how a terminal displays echoed input, so that
+// line editing done by the shell (backspace, cursor movement, erasing)
+// is reflected in the resulting text.
+type lineRenderer struct {
+	line    []rune
+	cursor  int
+	pending []byte
+}
+
+func (l *lineRenderer) Reset() {
+	l.line = l.line[:0]
+	l.cursor = 0
+	l.pending = l.pending[:0]
+}
+
+func (l *lineRenderer) String() string {
+	return strings.TrimSpace(string(l.line))
+}
+
+func (l *lineRenderer) Feed(seq sequence) {
+	switch seq.Kind {
+	case seqText:
+		l.text(seq.Raw)
+	case seqCSI:
+		l.csi(seq.Raw)
+	}
+}
+
+func (l *lineRenderer) text(data []byte) {
+	data = append(l.pending, data...)
+	l.pending = l.pending[:0]
+
+	for len(data) > 0 {
+		if !utf8.FullRune(data) {
+			l.pending = append(l.pending, data...)
+			return
+		}
+		r, size := utf8.DecodeRune(data)
+		data = data[size:]
+
+		switch {
+		case r == '\b':
+			if l.cursor > l.lineStart() {
+				l.cursor--
+			}
+		case r == '\r':
+			l.cursor = l.lineStart()
+		case r == '\n':
+			l.cursor = len(l.line)
+			l.put('\n')
+		case r < 0x20 || r == 0x7f:
+			// other control characters have no visible effect
+		default:
+			l.put(r)
+		}
+	}
+}
+
+func (l *lineRenderer) put(r rune) {
+	if l.cursor >= maxRenderedLine {
+		return
+	}
+	if l.cursor < len(l.line) {
+		l.line[l.cursor] = r
+	} else {
+		l.line = append(l.line, r)
+	}
+	l.cursor++
+}
+
+// lineStart returns the index of the first rune of the line the cursor is on.
+func (l *lineRenderer) lineStart() int {
+	for i := l.cursor - 1; i >= 0; i-- {
+		if l.line[i] == '\n' {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (l *lineRenderer) csi(raw []byte) {
+	if len(raw) < 3 {
+		return
+	}
+	final := raw[len(raw)-1]
+	params := string(raw[2 : len(raw)-1])
+	n, err := strconv.Atoi(params)
+	if errors.Is(err, strconv.ErrRange) && n > 0 {
+		n = maxRenderedLine
+	} else if err != nil || n < 1 {
+		n = 1
+	}
+	if n > maxRenderedLine {
+		n = maxRenderedLine
+	}
+
+	switch final {
+	case 'C': // cursor forward
+		l.cursor += n
+		if l.cursor > maxRenderedLine {
+			l.cursor = maxRenderedLine
+		}
+		for len(l.line) < l.cursor {
+			l.line = append(l.line, ' ')
+		}
+	case 'D': // cursor back
+		l.cursor -= n
+		if start := l.lineStart(); l.cursor < start {
+			l.cursor = start
+		}
+	case 'K': // erase in line
+		switch params {
+		case "", "0":
+			l.line = l.line[:l.cursor]
+		case "2":
+			l.line = l.line[:l.lineStart()]
+			l.cursor = len(l.line)
+		}
+	case 'P': // delete characters
+		end := l.cursor + n
+		if end > len(l.line) {
+			end = len(l.line)
+		}
+		l.line = append(l.line[:l.cursor], l.line[end:]...)
+	case '@': // insert blanks
+		if l.cursor <= len(l.line) {
+			blanks := []rune(strings.Repeat(" ", n))
+			l.line = append(l.line[:l.cursor], append(blanks, l.line[l.cursor:]...)...)
+			if len(l.line) > maxRenderedLine {
+				l.line = l.line[:maxRenderedLine]
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package tty
+
+import (
+	"testing"
+)
+
+func TestCommandTracker(t *testing.T) {
+	var (
+		tracker  commandTracker
+		finished []CommandEvent
+	)
+
+	// Output as it arrives from the slave, with marks split across reads.
+	chunks := []string{
+		"\x1b]133;A\x07$ \x1b]133;B\x07",
+		"lss\b\x1b[K -l",
+		"a\r\n\x1b]133;C\x07total 0\r\n\x1b]13",
+		"3;D;2\x1b\\",
+		"\x1b]133;A\x07$ \x1b]133;B\x07\x1b]133;D\x07",
+		"\x1b]133;A\x07$ \x1b]133;B\x07echo hi\r\n\x1b]133;C;cmdline_url=echo%20%22hi%22\x07hi\r\n",
+		"\x1b]133;A\x07",
+	}
+	for i, chunk := range chunks {
+		tracker.Feed([]byte(chunk), FramePosition{Segment: 1, Frame: i}, func(event *CommandEvent) {
+			finished = append(finished, *event)
+		})
+	}
+
+	if len(finished) != 2 {
+		t.Fatalf("expected 2 commands, got %d", len(finished))
+	}
+
+	first := finished[0]
+	if first.Command != "ls -la" {
+		t.Errorf("expected command %q, got %q", "ls -la", first.Command)
+	}
+	if first.ExitCode == nil || *first.ExitCode != 2 {
+		t.Errorf("expected exit code 2, got %v", first.ExitCode)
+	}
+	if first.StartFrame.Frame != 2 || first.EndFrame.Frame != 3 {
+		t.Errorf("expected frames 2-3, got %d-%d", first.StartFrame.Frame, first.EndFrame.Frame)
+	}
+
+	second := finished[1]
+	if second.Command != `echo "hi"` {
+		t.Errorf("expected command %q, got %q", `echo "hi"`, second.Command)
+	}
+	if second.ExitCode != nil {
+		t.Errorf("expected no exit code, got %d", *second.ExitCode)
+	}
+}
+
+func TestLineRenderer_HugeCounts(t *testing.T) {
+	var (
+		tracker  commandTracker
+		finished []CommandEvent
+	)
+
+	chunks := []string{
+		"\x1b]133;A\x07$ \x1b]133;B\x07ls",
+		"\x1b[9223372036854775807C\x1b[99999999999999999999D\x1b[2C -l",
+		"\x1b[2147483647@\x1b[4294967295P\r\n\x1b]133;C\x07\x1b]133;D;0\x07",
+	}
+	for i, chunk := range chunks {
+		tracker.Feed([]byte(chunk), FramePosition{Segment: 1, Frame: i}, func(event *CommandEvent) {
+			finished = append(finished, *event)
+		})
+	}
+
+	if len(finished) != 1 {
+		t.Fatalf("expected 1 command, got %d", len(finished))
+	}
+	if finished[0].Command != "ls -l" {
+		t.Errorf("expected command %q, got %q", "ls -l", finished[0].Command)
+	}
+	if n := len(tracker.echo.line); n > maxRenderedLine {
+		t.Errorf("expected the rendered line to stay within %d runes, got %d", maxRenderedLine, n)
+	}
+}
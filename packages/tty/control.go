@@ -0,0 +1,152 @@
+package tty
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// ControlAction is an intervention of a supervisor in a live session.
+type ControlAction string
+
+const (
+	// ControlFreeze discards the input of every master until ControlUnfreeze.
+	ControlFreeze ControlAction = "freeze"
+	// ControlUnfreeze lets the masters permitted to write type again.
+	ControlUnfreeze ControlAction = "unfreeze"
+	// ControlNotice shows the message of the request on the masters.
+	ControlNotice ControlAction = "notice"
+	// ControlTerminate ends the session, the message being the reason.
+	ControlTerminate ControlAction = "terminate"
+)
+
+// ControlRequest is the payload of a MessageControl sent by a supervisor.
+type ControlRequest struct {
+	Action  ControlAction `json:"action"`
+	Message string        `json:"message,omitempty"`
+}
+
+// ControlReply is the payload of the MessageControl answering a ControlRequest.
+type ControlReply struct {
+	Error string `json:"error,omitempty"`
+}
+
+// ControlEvent records an action of a supervisor.
+type ControlEvent struct {
+	AuditHeader
+
+	Supervisor string        `json:"supervisor"`
+	Action     ControlAction `json:"action"`
+	Message    string        `json:"message,omitempty"`
+}
+
+// WithSupervisors allows the listed users to control the session when they
+// connect to the session socket with ModeControl, see Control.
+func WithSupervisors(users ...string) Option {
+	return func(ptty *ProxyTTY) error {
+		ptty.supervisors = users
+		return nil
+	}
+}
+
+// Control carries out the request of supervisor and records it in the audit log.
+func (ptty *ProxyTTY) Control(supervisor string, req ControlRequest) error {
+	message := printable(req.Message)
+
+	var notice string
+	switch req.Action {
+	case ControlFreeze:
+		ptty.setFrozen(true)
+		notice = fmt.Sprintf("input frozen by %s", supervisor)
+	case ControlUnfreeze:
+		ptty.setFrozen(false)
+		notice = fmt.Sprintf("input unfrozen by %s", supervisor)
+	case ControlNotice:
+		if message == "" {
+			return fmt.Errorf("notice without a message")
+		}
+		notice = fmt.Sprintf("message from %s: %s", supervisor, message)
+	case ControlTerminate:
+		notice = fmt.Sprintf("session terminated by %s", supervisor)
+		if message != "" {
+			notice += ": " + message
+		}
+	default:
+		return fmt.Errorf("unknown control action %q", req.Action)
+	}
+
+	ptty.audit(&ControlEvent{
+		AuditHeader: AuditHeader{Type: "control", Time: time.Now()},
+		Supervisor:  supervisor,
+		Action:      req.Action,
+		Message:     message,
+	})
+	ptty.masterWrite([]byte("\r\nqudosh: " + notice + "\r\n"))
+
+	if req.Action == ControlTerminate {
+		ptty.terminate(notice, ErrTerminatedBySupervisor)
+	}
+	return nil
+}
+
+func (ptty *ProxyTTY) setFrozen(frozen bool) {
+	ptty.inputMutex.Lock()
+	ptty.frozen = frozen
+	ptty.inputMutex.Unlock()
+}
+
+// printable drops control characters, so that notices cannot mess with the
+// terminals of the masters.
+func printable(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || (r >= 0x80 && r < 0xa0) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// handleControl serves the requests of a client that connected with ModeControl.
+// Only supervisors identified by the credentials of the socket peer are served:
+// the user of the Hello is whatever the client claims.
+func (s *watchServer) handleControl(conn net.Conn, hello Hello, verified bool) {
+	reply := func(err error) error {
+		var r ControlReply
+		if err != nil {
+			r.Error = err.Error()
+		}
+		data, _ := json.Marshal(r)
+		return WriteMessage(conn, MessageControl, data)
+	}
+
+	if !verified {
+		reply(fmt.Errorf("the user of the client cannot be verified"))
+		return
+	}
+	if !containsString(s.ptty.supervisors, hello.User) {
+		reply(fmt.Errorf("%s is not allowed to control the session", hello.User))
+		return
+	}
+
+	for {
+		t, payload, err := ReadMessage(conn)
+		if err != nil {
+			return
+		}
+		if t != MessageControl {
+			continue
+		}
+
+		var req ControlRequest
+		if err := json.Unmarshal(payload, &req); err != nil {
+			err = reply(fmt.Errorf("invalid control request: %w", err))
+		} else {
+			err = reply(s.ptty.Control(hello.User, req))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
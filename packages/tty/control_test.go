@@ -0,0 +1,177 @@
+package tty
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testSlave struct {
+	bytes.Buffer
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func (s *testSlave) WindowTitleVariables() map[string]interface{} { return nil }
+func (s *testSlave) ResizeTerminal(columns int, rows int) error   { return nil }
+func (s *testSlave) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	return nil
+}
+
+// isClosed reports whether the slave is closed within wait, as
+// terminate closes it in the background.
+func (s *testSlave) isClosed(wait time.Duration) bool {
+	deadline := time.Now().Add(wait)
+	for {
+		s.mu.Lock()
+		closed := s.closed
+		s.mu.Unlock()
+		if closed || time.Now().After(deadline) {
+			return closed
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestProxyTTY_Control(t *testing.T) {
+	slave := &testSlave{}
+	var stdout bytes.Buffer
+	ptty, err := New(nil, &stdout, slave, WithPermitWrite())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ptty.Control("sec", ControlRequest{Action: ControlFreeze}); err != nil {
+		t.Fatal(err)
+	}
+	ptty.Input(ptty.owner, []byte("ls"))
+	if slave.Len() != 0 {
+		t.Errorf("frozen session got input %q", slave.String())
+	}
+
+	if err := ptty.Control("sec", ControlRequest{Action: ControlUnfreeze}); err != nil {
+		t.Fatal(err)
+	}
+	ptty.Input(ptty.owner, []byte("ls"))
+	if slave.String() != "ls" {
+		t.Errorf("unfrozen session got input %q, want %q", slave.String(), "ls")
+	}
+
+	if err := ptty.Control("sec", ControlRequest{Action: ControlNotice, Message: "stop\x1b[2J now"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), "qudosh: message from sec: stop[2J now\r\n") {
+		t.Errorf("notice missing or not sanitised: %q", stdout.String())
+	}
+
+	if err := ptty.Control("sec", ControlRequest{Action: "reboot"}); err == nil {
+		t.Error("unknown action accepted")
+	}
+
+	if err := ptty.Control("sec", ControlRequest{Action: ControlTerminate, Message: "incident"}); err != nil {
+		t.Fatal(err)
+	}
+	if closed := slave.isClosed(time.Second); !closed || ptty.terminationError() != ErrTerminatedBySupervisor {
+		t.Errorf("session not terminated: closed %v, error %v", closed, ptty.terminationError())
+	}
+}
+
+// socketPair returns both ends of a connection over a Unix socket.
+func socketPair(t *testing.T) (client, server net.Conn) {
+	t.Helper()
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "session.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+	client, err = net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server = <-accepted
+	if server == nil {
+		t.Fatal("accept failed")
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestWatchServer_ControlPeer(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		pipe        bool
+		supervisors []string
+		wantErr     bool
+	}{
+		{name: "unverified peer", pipe: true, supervisors: []string{"sec"}, wantErr: true},
+		{name: "not a supervisor", supervisors: []string{"sec"}, wantErr: true},
+		{name: "supervisor", supervisors: []string{current.Username}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.pipe && runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+				t.Skip("peer credentials are not supported")
+			}
+
+			ptty, err := New(nil, &bytes.Buffer{}, &testSlave{}, WithSupervisors(tt.supervisors...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &watchServer{ptty: ptty, clients: map[*watcher]struct{}{}}
+
+			var client, server net.Conn
+			if tt.pipe {
+				client, server = net.Pipe()
+				defer client.Close()
+			} else {
+				client, server = socketPair(t)
+			}
+			go s.handle(server)
+
+			// The client claims to be a supervisor, only its credentials count.
+			go func() {
+				WriteHello(client, Hello{Mode: ModeControl, User: "sec"})
+				request, _ := json.Marshal(ControlRequest{Action: ControlNotice, Message: "hello"})
+				WriteMessage(client, MessageControl, request)
+			}()
+
+			typ, payload, err := ReadMessage(client)
+			if err != nil || typ != MessageControl {
+				t.Fatalf("expected a control reply, got %q %v", typ, err)
+			}
+			var reply ControlReply
+			if err := json.Unmarshal(payload, &reply); err != nil {
+				t.Fatal(err)
+			}
+			if (reply.Error != "") != tt.wantErr {
+				t.Errorf("reply error %q, want error %v", reply.Error, tt.wantErr)
+			}
+		})
+	}
+}
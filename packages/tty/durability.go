@@ -0,0 +1,127 @@
+package tty
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// incompleteSuffix is appended to the files of a segment until the segment is
+// closed cleanly, so that the files left behind by a crash are recognisable.
+const incompleteSuffix = ".incomplete"
+
+// SyncByDuration flushes the recording to disk at least every d.
+func SyncByDuration(d time.Duration) RecorderOption {
+	return func(r *Recorder) {
+		r.syncDuration = d
+	}
+}
+
+// SyncBySize flushes the recording to disk once size bytes have been
+// recorded since the last flush.
+func SyncBySize(size int64) RecorderOption {
+	return func(r *Recorder) {
+		r.syncSize = size
+	}
+}
+
+// SyncOnCommand flushes the recording to disk at every command boundary: when
+// a command reported by the shell ends or a line is submitted on the master.
+func SyncOnCommand() RecorderOption {
+	return func(r *Recorder) {
+		r.syncOnCommand = true
+	}
+}
+
+// createIncomplete creates the file name of a segment under its incomplete name.
+func createIncomplete(filePrefix, name string) (*os.File, error) {
+	path := fmt.Sprintf("%s/%s%s", filePrefix, name, incompleteSuffix)
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error opening %s", path)
+	}
+	return f, nil
+}
+
+// sync flushes the files of the current segment to disk, on the writer
+// goroutine with WriteAsync. It must be called with mu held.
+func (r *Recorder) sync() {
+	s := r.segment
+	s.unsynced = 0
+	s.syncedAt = time.Now()
+
+	err := r.perform(func() error {
+		for _, f := range s.files() {
+			if err := f.Sync(); err != nil {
+				return errors.Wrapf(err, "error syncing %s", f.Name())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// commandBoundary reports whether event ends a command, see SyncOnCommand.
+func commandBoundary(event interface{}) bool {
+	switch event.(type) {
+	case *CommandEvent, *InputLineEvent:
+		return true
+	}
+	return false
+}
+
+// files returns the open files of the segment.
+func (s *segment) files() []*os.File {
+	var files []*os.File
+	for _, f := range []*os.File{s.file, s.metricsFile, s.audit} {
+		if f != nil {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// complete flushes and closes the files of the segment, then renames them to
+// their final names. It returns the names of the files relative to FilePrefix.
+func (s *segment) complete() []string {
+	var artifacts []string
+	for _, f := range s.files() {
+		if err := f.Sync(); err != nil {
+			log.Print(errors.Wrapf(err, "error syncing %s", f.Name()))
+		}
+		f.Close()
+
+		path := strings.TrimSuffix(f.Name(), incompleteSuffix)
+		if err := os.Rename(f.Name(), path); err != nil {
+			log.Print(errors.Wrapf(err, "error renaming %s", f.Name()))
+			path = f.Name()
+		}
+		artifacts = append(artifacts, strings.TrimPrefix(path, s.FilePrefix+"/"))
+	}
+
+	// The renames are only durable once the directory is.
+	if err := syncDir(filepath.Dir(filepath.Join(s.FilePrefix, s.FileName))); err != nil {
+		log.Print(err)
+	}
+	return artifacts
+}
+
+func syncDir(name string) error {
+	dir, err := os.Open(name)
+	if err != nil {
+		return errors.Wrapf(err, "error opening %s", name)
+	}
+	defer dir.Close()
+
+	if err := dir.Sync(); err != nil {
+		return errors.Wrapf(err, "error syncing %s", name)
+	}
+	return nil
+}
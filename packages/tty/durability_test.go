@@ -0,0 +1,76 @@
+package tty
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecorder_Incomplete(t *testing.T) {
+	var (
+		dir       = t.TempDir()
+		artifacts []string
+	)
+	upload := func(s *Segment) error {
+		artifacts = s.Artifacts
+		return nil
+	}
+
+	r, err := newRecorder(context.Background(), dir, "session.ttyrec", upload, WithMetadata(&Metadata{}), SyncBySize(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Write([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	if r.segment.unsynced != 0 {
+		t.Errorf("%d bytes left unsynced", r.segment.unsynced)
+	}
+	if err = r.Audit(&AuditHeader{Type: "test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"session.ttyrec", "session.ttyrec.csv", "session.ttyrec.audit.jsonl"} {
+		if _, err := os.Stat(filepath.Join(dir, name+incompleteSuffix)); err != nil {
+			t.Errorf("open segment: %v", err)
+		}
+	}
+	if m := readMetadata(t, filepath.Join(dir, "session.ttyrec.json")); !m.Incomplete {
+		t.Error("open segment not marked incomplete")
+	}
+
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(artifacts) != 4 {
+		t.Fatalf("expected 4 artifacts, got %v", artifacts)
+	}
+	for _, name := range artifacts {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("closed segment: %v", err)
+		}
+	}
+	if m := readMetadata(t, filepath.Join(dir, "session.ttyrec.json")); m.Incomplete {
+		t.Error("closed segment marked incomplete")
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*"+incompleteSuffix)); len(matches) > 0 {
+		t.Errorf("incomplete files left: %v", matches)
+	}
+}
+
+func readMetadata(t *testing.T, name string) *Metadata {
+	t.Helper()
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m Metadata
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	return &m
+}
@@ -13,4 +13,19 @@ var (
 
 	// ErrConnectionLostPing is returned if no ping within a duration
 	ErrConnectionLostPing = errors.New("connection lost ping")
+
+	// ErrSessionIdle is returned when the session was idle for longer than its idle timeout.
+	ErrSessionIdle = errors.New("session idle")
+
+	// ErrSessionExpired is returned when the session reached its maximum duration or expiry time.
+	ErrSessionExpired = errors.New("session expired")
+
+	// ErrTerminatedBySupervisor is returned when a supervisor terminated the session.
+	ErrTerminatedBySupervisor = errors.New("terminated by supervisor")
+
+	// ErrRecorderClosed is returned when writing to a closed Recorder.
+	ErrRecorderClosed = errors.New("recorder closed")
+
+	// ErrRecordingStalled is returned when the recording cannot keep up with the output, see QueueTerminate.
+	ErrRecordingStalled = errors.New("recording stalled")
 )
@@ -0,0 +1,329 @@
+package tty
+
+import (
+	"bytes"
+)
+
+const (
+	esc = 0x1b
+	bel = 0x07
+	can = 0x18
+	sub = 0x1a
+
+	// c1Lead is the first byte of the UTF-8 encoding of the C1 controls
+	// (U+0080 to U+009F), which terminals honour like their ESC forms.
+	c1Lead = 0xc2
+	c1ST   = 0x9c
+
+	// maxSequenceLen bounds the memory used by a single buffered sequence.
+	// Longer sequences are emitted in several parts, see sequence.Partial.
+	maxSequenceLen = 64 * 1024
+)
+
+type sequenceKind int
+
+const (
+	// seqText is printable text along with C0 control characters.
+	seqText sequenceKind = iota
+	// seqEscape is ESC followed by intermediate bytes and a final byte.
+	seqEscape
+	// seqCSI is a Control Sequence Introducer sequence, ESC [ or U+009B.
+	seqCSI
+	// seqOSC is an Operating System Command, ESC ] or U+009D.
+	seqOSC
+	// seqDCS is a Device Control String, ESC P or U+0090.
+	seqDCS
+	// seqString is one of the SOS, PM or APC strings (ESC X, ESC ^, ESC _
+	// or U+0098, U+009E, U+009F).
+	seqString
+)
+
+// sequence is a piece of terminal output emitted by escapeParser.
+// Raw is only valid until the emit callback returns.
+type sequence struct {
+	Kind sequenceKind
+	Raw  []byte
+
+	// Partial is set on every part of a string sequence that exceeded
+	// maxSequenceLen except the last one.
+	Partial bool
+	// Continued is set on the parts following the first part of such a sequence.
+	Continued bool
+}
+
+// Payload returns the contents of a string sequence without the
+// introducer and the terminator. For other kinds it returns Raw.
+func (s sequence) Payload() []byte {
+	switch s.Kind {
+	case seqOSC, seqDCS, seqString:
+	default:
+		return s.Raw
+	}
+
+	p := s.Raw
+	if !s.Continued && len(p) >= 2 {
+		p = p[2:]
+	}
+	if !s.Partial {
+		if bytes.HasSuffix(p, []byte{esc, '\\'}) || bytes.HasSuffix(p, []byte{c1Lead, c1ST}) {
+			p = p[:len(p)-2]
+		} else if bytes.HasSuffix(p, []byte{bel}) {
+			p = p[:len(p)-1]
+		}
+	}
+	return p
+}
+
+type parserState int
+
+const (
+	stateGround parserState = iota
+	stateC1
+	stateEscape
+	stateEscapeIntermediate
+	stateCSI
+	stateString
+	stateStringEscape
+	stateStringC1
+)
+
+// escapeParser splits a stream of terminal output into text runs and
+// escape sequences. Sequences may span several calls to Parse. Besides
+// ESC, the C1 introducers and string terminator are recognised in their
+// UTF-8 encoding; the 8-bit forms are not valid UTF-8 and left alone.
+type escapeParser struct {
+	state     parserState
+	kind      sequenceKind
+	buf       []byte
+	continued bool
+}
+
+// Parse feeds data to the parser, calling emit for every text run and
+// every complete escape sequence in order.
+func (p *escapeParser) Parse(data []byte, emit func(seq sequence)) {
+	text := -1
+	flushText := func(end int) {
+		if text >= 0 && end > text {
+			emit(sequence{Kind: seqText, Raw: data[text:end]})
+		}
+		text = -1
+	}
+
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+
+		switch p.state {
+		case stateGround:
+			if b == esc {
+				flushText(i)
+				p.start(b)
+				continue
+			}
+			if b == c1Lead {
+				flushText(i)
+				p.buf = append(p.buf[:0], b)
+				p.state = stateC1
+				continue
+			}
+			if text < 0 {
+				text = i
+			}
+
+		case stateC1:
+			switch b {
+			case 0x9b:
+				p.buf = append(p.buf, b)
+				p.kind = seqCSI
+				p.state = stateCSI
+			case 0x9d, 0x90, 0x98, 0x9e, 0x9f:
+				p.buf = append(p.buf, b)
+				p.kind = c1StringKind(b)
+				p.state = stateString
+			default:
+				// Any other character starting with the same byte is text.
+				emit(sequence{Kind: seqText, Raw: p.buf})
+				p.reset()
+				i--
+			}
+
+		case stateEscape:
+			switch {
+			case b == can || b == sub:
+				p.reset()
+			case b == esc:
+				p.emit(seqEscape, emit)
+				p.start(b)
+			case b == '[':
+				p.buf = append(p.buf, b)
+				p.kind = seqCSI
+				p.state = stateCSI
+			case b == ']' || b == 'P' || b == 'X' || b == '^' || b == '_':
+				p.buf = append(p.buf, b)
+				p.kind = stringKind(b)
+				p.state = stateString
+			case b >= 0x20 && b <= 0x2f:
+				p.buf = append(p.buf, b)
+				p.state = stateEscapeIntermediate
+			default:
+				p.buf = append(p.buf, b)
+				p.emit(seqEscape, emit)
+			}
+
+		case stateEscapeIntermediate:
+			switch {
+			case b == can || b == sub:
+				p.reset()
+			case b >= 0x20 && b <= 0x2f:
+				p.buf = append(p.buf, b)
+			default:
+				p.buf = append(p.buf, b)
+				p.emit(seqEscape, emit)
+			}
+
+		case stateCSI:
+			switch {
+			case b == can || b == sub:
+				p.reset()
+			case b == esc:
+				p.emit(seqCSI, emit)
+				p.start(b)
+			case b >= 0x40 && b <= 0x7e:
+				p.buf = append(p.buf, b)
+				p.emit(seqCSI, emit)
+			default:
+				p.buf = append(p.buf, b)
+				if len(p.buf) > maxSequenceLen {
+					// Nobody sends parameters this long, give up on the sequence.
+					p.emit(seqCSI, emit)
+				}
+			}
+
+		case stateString:
+			switch b {
+			case can, sub:
+				p.reset()
+			case bel:
+				p.buf = append(p.buf, b)
+				p.emit(p.kind, emit)
+			case esc:
+				p.buf = append(p.buf, b)
+				p.state = stateStringEscape
+			case c1Lead:
+				p.buf = append(p.buf, b)
+				p.state = stateStringC1
+			default:
+				p.buf = append(p.buf, b)
+				if len(p.buf) >= maxSequenceLen {
+					emit(sequence{Kind: p.kind, Raw: p.buf, Partial: true, Continued: p.continued})
+					p.buf = p.buf[:0]
+					p.continued = true
+				}
+			}
+
+		case stateStringEscape:
+			if b == '\\' {
+				p.buf = append(p.buf, b)
+				p.emit(p.kind, emit)
+				continue
+			}
+			// The string was interrupted by another escape sequence.
+			p.buf = p.buf[:len(p.buf)-1]
+			p.emit(p.kind, emit)
+			p.start(esc)
+			i--
+
+		case stateStringC1:
+			if b == c1ST {
+				p.buf = append(p.buf, b)
+				p.emit(p.kind, emit)
+				continue
+			}
+			p.state = stateString
+			i--
+		}
+	}
+
+	flushText(len(data))
+}
+
+// unfinished returns the buffered part of an unfinished string sequence,
+// without the first byte of a terminator that may follow.
+func (p *escapeParser) unfinished() (sequence, bool) {
+	n := len(p.buf)
+	switch p.state {
+	case stateString:
+	case stateStringEscape, stateStringC1:
+		n--
+	default:
+		return sequence{}, false
+	}
+	return sequence{Kind: p.kind, Raw: p.buf[:n], Partial: true, Continued: p.continued}, n > 0
+}
+
+// split emits the part returned by unfinished, the rest of the string
+// sequence is emitted as its continuation.
+func (p *escapeParser) split(emit func(seq sequence)) {
+	seq, ok := p.unfinished()
+	if !ok {
+		return
+	}
+	emit(seq)
+	p.buf = append(p.buf[:0], p.buf[len(seq.Raw):]...)
+	p.continued = true
+}
+
+// end emits what is buffered as if the stream ended there: a lone C1 lead
+// byte as text, an unfinished sequence as it is.
+func (p *escapeParser) end(emit func(seq sequence)) {
+	kind := p.kind
+	switch p.state {
+	case stateGround:
+		return
+	case stateC1:
+		kind = seqText
+	case stateEscape, stateEscapeIntermediate:
+		kind = seqEscape
+	}
+	p.emit(kind, emit)
+}
+
+func (p *escapeParser) start(b byte) {
+	p.buf = append(p.buf[:0], b)
+	p.state = stateEscape
+	p.continued = false
+}
+
+func (p *escapeParser) reset() {
+	p.buf = p.buf[:0]
+	p.state = stateGround
+	p.continued = false
+}
+
+func (p *escapeParser) emit(kind sequenceKind, emit func(seq sequence)) {
+	emit(sequence{Kind: kind, Raw: p.buf, Continued: p.continued})
+	p.reset()
+}
+
+// c1StringKind returns the kind of the string introduced by the C1 control
+// encoded as c1Lead followed by b.
+func c1StringKind(b byte) sequenceKind {
+	switch b {
+	case 0x9d:
+		return seqOSC
+	case 0x90:
+		return seqDCS
+	default:
+		return seqString
+	}
+}
+
+func stringKind(b byte) sequenceKind {
+	switch b {
+	case ']':
+		return seqOSC
+	case 'P':
+		return seqDCS
+	default:
+		return seqString
+	}
+}
@@ -0,0 +1,170 @@
+package tty
+
+import (
+	"context"
+	"time"
+)
+
+// FilterFlushInterval is how often filters holding bytes back are flushed.
+var FilterFlushInterval = 100 * time.Millisecond
+
+// Filter transforms a byte stream of the session, one chunk at a time.
+type Filter interface {
+	// Filter is called with every chunk of the stream in order and returns
+	// the bytes to pass on instead: data itself to pass it, other bytes to
+	// rewrite it, nothing to drop it. Bytes held back may be returned along
+	// with a later chunk or by Flush.
+	Filter(data []byte) []byte
+}
+
+// Flusher is implemented by filters that hold bytes back. Flush returns the
+// bytes held back that are due. It is called every FilterFlushInterval and
+// with final set once the session ends, when everything has to be returned.
+type Flusher interface {
+	Flush(final bool) []byte
+}
+
+// FilterTarget selects the copies of the output a filter applies to.
+type FilterTarget int
+
+const (
+	// TargetTerminal is the output written to the masters.
+	TargetTerminal FilterTarget = 1 << iota
+	// TargetRecording is the output written to the recording and audited.
+	TargetRecording
+	// TargetBoth applies a filter before the output is split.
+	TargetBoth = TargetTerminal | TargetRecording
+)
+
+// WithInputFilter appends f to the chain run on the input of the masters that
+// are permitted to write. Input is audited as the slave gets it, so input
+// filters apply to both.
+func WithInputFilter(f Filter) Option {
+	return func(ptty *ProxyTTY) error {
+		ptty.input.filters = append(ptty.input.filters, f)
+		return nil
+	}
+}
+
+// WithOutputFilter appends f to the chain run on the output of the slave.
+// Filters targeting both run first, then the output is split in a copy for
+// the masters and one for the recording, each with its own chain.
+func WithOutputFilter(f Filter, target FilterTarget) Option {
+	return func(ptty *ProxyTTY) error {
+		switch target {
+		case TargetBoth:
+			ptty.output.both.filters = append(ptty.output.both.filters, f)
+		case TargetTerminal:
+			ptty.output.terminal.filters = append(ptty.output.terminal.filters, f)
+		case TargetRecording:
+			ptty.output.recording.filters = append(ptty.output.recording.filters, f)
+		}
+		return nil
+	}
+}
+
+// filterEvent is an audit event raised by a filter. It is recorded with the
+// frame of the output it belongs to, see ProxyTTY.writeOutput.
+type filterEvent interface {
+	Event
+	at(pos FramePosition)
+}
+
+// filterChain runs a chunk through filters in order.
+type filterChain struct {
+	filters []Filter
+}
+
+func (c *filterChain) run(data []byte) []byte {
+	return c.runFrom(0, data)
+}
+
+func (c *filterChain) runFrom(i int, data []byte) []byte {
+	for ; i < len(c.filters) && len(data) > 0; i++ {
+		data = c.filters[i].Filter(data)
+	}
+	return data
+}
+
+// flush collects the bytes due in the filters, each run through the filters
+// following the one that held it back.
+func (c *filterChain) flush(final bool) []byte {
+	var out []byte
+	for i, f := range c.filters {
+		if flusher, ok := f.(Flusher); ok {
+			out = append(out, c.runFrom(i+1, flusher.Flush(final))...)
+		}
+	}
+	return out
+}
+
+func (c *filterChain) flushes() bool {
+	for _, f := range c.filters {
+		if _, ok := f.(Flusher); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// outputPipeline splits the output of the slave in the copies for the
+// masters and the recording. It is used with outputMutex held.
+type outputPipeline struct {
+	both      filterChain
+	terminal  filterChain
+	recording filterChain
+}
+
+// Process returns the live and recorded copies of data.
+func (p *outputPipeline) Process(data []byte) (live, record []byte) {
+	data = p.both.run(data)
+	return p.terminal.run(data), p.recording.run(data)
+}
+
+// Flush returns the live and recorded copies of the bytes held back that are due.
+func (p *outputPipeline) Flush(final bool) (live, record []byte) {
+	data := p.both.flush(final)
+	live = append(p.terminal.run(data), p.terminal.flush(final)...)
+	record = append(p.recording.run(data), p.recording.flush(final)...)
+	return live, record
+}
+
+func (p *outputPipeline) flushes() bool {
+	return p.both.flushes() || p.terminal.flushes() || p.recording.flushes()
+}
+
+// flushFilters releases the bytes held back by filters until ctx is done.
+func (ptty *ProxyTTY) flushFilters(ctx context.Context) error {
+	ticker := time.NewTicker(FilterFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		if err := ptty.flushOutput(false); err != nil {
+			return err
+		}
+		if err := ptty.flushInput(false); err != nil {
+			return err
+		}
+	}
+}
+
+func (ptty *ProxyTTY) flushOutput(final bool) error {
+	ptty.outputMutex.Lock()
+	defer ptty.outputMutex.Unlock()
+
+	live, record := ptty.output.Flush(final)
+	return ptty.writeOutput(live, record)
+}
+
+func (ptty *ProxyTTY) flushInput(final bool) error {
+	ptty.inputMutex.Lock()
+	defer ptty.inputMutex.Unlock()
+
+	return ptty.writeInput(ptty.input.flush(final))
+}
@@ -0,0 +1,88 @@
+package tty
+
+import (
+	"bytes"
+	"testing"
+)
+
+type filterFunc func(data []byte) []byte
+
+func (f filterFunc) Filter(data []byte) []byte { return f(data) }
+
+// holdFilter holds everything back until it is flushed.
+type holdFilter struct {
+	held []byte
+}
+
+func (f *holdFilter) Filter(data []byte) []byte {
+	f.held = append(f.held, data...)
+	return nil
+}
+
+func (f *holdFilter) Flush(final bool) []byte {
+	if !final {
+		return nil
+	}
+	held := f.held
+	f.held = nil
+	return held
+}
+
+func TestOutputPipeline(t *testing.T) {
+	redact := filterFunc(func(data []byte) []byte {
+		return bytes.ReplaceAll(data, []byte("hunter2"), []byte("*******"))
+	})
+	drop := filterFunc(func(data []byte) []byte { return nil })
+
+	var p outputPipeline
+	p.both.filters = []Filter{filterFunc(bytes.ToUpper)}
+	p.recording.filters = []Filter{redact}
+
+	live, record := p.Process([]byte("pw hunter2"))
+	if string(live) != "PW HUNTER2" || string(record) != "PW HUNTER2" {
+		t.Errorf("got live %q and record %q", live, record)
+	}
+	live, record = p.Process([]byte("\x1b[1mhunter2"))
+	if string(live) != "\x1b[1MHUNTER2" || string(record) != "\x1b[1MHUNTER2" {
+		t.Errorf("got live %q and record %q", live, record)
+	}
+
+	p.both.filters = []Filter{redact}
+	p.terminal.filters = []Filter{drop}
+	live, record = p.Process([]byte("pw hunter2"))
+	if live != nil || string(record) != "pw *******" {
+		t.Errorf("got live %q and record %q", live, record)
+	}
+
+	// Held bytes are run through the filters following the one holding them.
+	hold := &holdFilter{}
+	p.both.filters = []Filter{hold, redact}
+	p.terminal.filters = nil
+	if live, record = p.Process([]byte("hunter2")); live != nil || record != nil {
+		t.Errorf("held bytes passed: live %q and record %q", live, record)
+	}
+	if live, record = p.Flush(false); len(live) != 0 || len(record) != 0 {
+		t.Errorf("held bytes flushed early: live %q and record %q", live, record)
+	}
+	live, record = p.Flush(true)
+	if string(live) != "*******" || string(record) != "*******" {
+		t.Errorf("got live %q and record %q", live, record)
+	}
+}
+
+func TestProxyTTY_InputFilter(t *testing.T) {
+	slave := &testSlave{}
+	noBell := filterFunc(func(data []byte) []byte {
+		return bytes.ReplaceAll(data, []byte{bel}, nil)
+	})
+	ptty, err := New(nil, &bytes.Buffer{}, slave, WithPermitWrite(), WithInputFilter(noBell))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ptty.Input(ptty.owner, []byte("l\as"))
+	ptty.Input(ptty.owner, []byte{bel})
+	if slave.String() != "ls" {
+		t.Errorf("slave got %q, want %q", slave.String(), "ls")
+	}
+}
@@ -0,0 +1,164 @@
+package tty
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FloodEvent records output left out of the recording while it flooded.
+type FloodEvent struct {
+	AuditHeader
+
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration"`
+	Omitted   int64         `json:"omitted"`
+	// Frame holds the summary marker written in place of the output.
+	Frame FramePosition `json:"frame"`
+}
+
+func (e *FloodEvent) at(pos FramePosition) {
+	e.Time = time.Now()
+	e.Frame = pos
+}
+
+// WithOutputRateLimit limits the output forwarded to the masters to rate bytes
+// per second, allowing bursts of burst bytes. The slave is slowed down to
+// match, while input keeps flowing, so that e.g. Ctrl-C still works.
+func WithOutputRateLimit(rate, burst int) Option {
+	return func(ptty *ProxyTTY) error {
+		if rate <= 0 {
+			return fmt.Errorf("invalid output rate %d", rate)
+		}
+		if burst < rate {
+			burst = rate
+		}
+		ptty.outputLimiter = &rateLimiter{
+			rate:   float64(rate),
+			burst:  float64(burst),
+			tokens: float64(burst),
+			last:   time.Now(),
+		}
+		return nil
+	}
+}
+
+// WithFloodSummary leaves the output out of the recording while the slave
+// writes more than threshold bytes per second. Once the flood is over, a
+// summary marker is recorded in its place along with a FloodEvent.
+func WithFloodSummary(threshold int) Option {
+	return func(ptty *ProxyTTY) error {
+		if threshold <= 0 {
+			return fmt.Errorf("invalid flood threshold %d", threshold)
+		}
+		f := &floodFilter{
+			threshold: threshold,
+			flooded: func(event *FloodEvent) {
+				ptty.filterEvents = append(ptty.filterEvents, event)
+			},
+		}
+		return WithOutputFilter(f, TargetRecording)(ptty)
+	}
+}
+
+// rateLimiter is a token bucket of bytes.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes n bytes from the bucket and returns how long to wait
+// before they may be sent.
+func (l *rateLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// floodFilter is the recording filter behind WithFloodSummary. A flood starts
+// when more than threshold bytes arrive within a second, and ends when the
+// rate measured between two flushes falls below threshold.
+type floodFilter struct {
+	threshold int
+	flooded   func(event *FloodEvent)
+
+	windowStart time.Time
+	windowBytes int
+
+	flooding   bool
+	floodStart time.Time
+	omitted    int64
+	lastCheck  time.Time
+	sinceCheck int
+}
+
+func (f *floodFilter) Filter(data []byte) []byte {
+	now := time.Now()
+	if !f.flooding {
+		if now.Sub(f.windowStart) >= time.Second {
+			f.windowStart = now
+			f.windowBytes = 0
+		}
+		f.windowBytes += len(data)
+		if f.windowBytes <= f.threshold {
+			return data
+		}
+
+		f.flooding = true
+		f.floodStart = now
+		f.omitted = 0
+		f.lastCheck = now
+		f.sinceCheck = 0
+	}
+
+	f.omitted += int64(len(data))
+	f.sinceCheck += len(data)
+	return nil
+}
+
+func (f *floodFilter) Flush(final bool) []byte {
+	if !f.flooding {
+		return nil
+	}
+
+	now := time.Now()
+	if !final {
+		elapsed := now.Sub(f.lastCheck).Seconds()
+		if elapsed <= 0 || float64(f.sinceCheck)/elapsed >= float64(f.threshold) {
+			f.lastCheck = now
+			f.sinceCheck = 0
+			return nil
+		}
+	}
+
+	f.flooding = false
+	f.windowStart = now
+	f.windowBytes = 0
+
+	duration := now.Sub(f.floodStart)
+	f.flooded(&FloodEvent{
+		AuditHeader: AuditHeader{Type: "flood"},
+		StartedAt:   f.floodStart,
+		Duration:    duration,
+		Omitted:     f.omitted,
+	})
+	return []byte(fmt.Sprintf(
+		"\x1b[0m\r\n[qudosh: %d bytes of output omitted over %s]\r\n",
+		f.omitted, duration.Round(time.Millisecond),
+	))
+}
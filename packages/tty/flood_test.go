@@ -0,0 +1,52 @@
+package tty
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFloodFilter(t *testing.T) {
+	var events []*FloodEvent
+	f := &floodFilter{
+		threshold: 500,
+		flooded:   func(event *FloodEvent) { events = append(events, event) },
+	}
+
+	chunk := []byte(strings.Repeat("x", 100))
+	recorded := 0
+	for i := 0; i < 10; i++ {
+		recorded += len(f.Filter(chunk))
+	}
+	if recorded != 500 {
+		t.Errorf("recorded %d bytes, want 500", recorded)
+	}
+
+	if marker := f.Flush(false); marker != nil {
+		t.Errorf("flood ended while flooding: %q", marker)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	marker := string(f.Flush(false))
+	if !strings.Contains(marker, "500 bytes of output omitted") {
+		t.Errorf("unexpected marker %q", marker)
+	}
+	if len(events) != 1 || events[0].Omitted != 500 {
+		t.Fatalf("unexpected events %v", events)
+	}
+
+	if out := f.Filter(chunk); len(out) != len(chunk) {
+		t.Errorf("output after the flood was dropped")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := &rateLimiter{rate: 1000, burst: 1000, tokens: 1000, last: time.Now()}
+
+	if wait := l.reserve(1000); wait != 0 {
+		t.Errorf("burst was delayed by %s", wait)
+	}
+	if wait := l.reserve(500); wait < 450*time.Millisecond || wait > 500*time.Millisecond {
+		t.Errorf("got wait %s, want about 500ms", wait)
+	}
+}
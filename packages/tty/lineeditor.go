@@ -0,0 +1,377 @@
+package tty
+
+import (
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// InputLineEvent is a command line reconstructed from the keystrokes
+// of the master when Enter was pressed.
+type InputLineEvent struct {
+	AuditHeader
+
+	Line string `json:"line"`
+	// Pasted is set when (part of) the line was inserted through bracketed paste.
+	Pasted bool `json:"pasted,omitempty"`
+	// HistoryRecall is set when the line was recalled from the history.
+	// Only lines seen by qudosh can be recalled, so the text may differ from
+	// what the shell executed.
+	HistoryRecall bool `json:"history_recall,omitempty"`
+	// Completion is set when Tab was pressed, the shell may have completed
+	// the line beyond what was typed.
+	Completion bool `json:"completion,omitempty"`
+	// Uncertain is set when keys that are not modelled, such as Tab, Ctrl-R
+	// or a recall beyond the history seen by qudosh, may have changed the
+	// line in ways Line does not reflect.
+	Uncertain bool `json:"uncertain,omitempty"`
+	// Participants lists the masters that typed (part of) the line.
+	Participants []string `json:"participants,omitempty"`
+	// Blocked is set when the command policy prevented the submission.
+	Blocked bool          `json:"blocked,omitempty"`
+	Frame   FramePosition `json:"frame"`
+}
+
+type keyState int
+
+const (
+	keyGround keyState = iota
+	keyEscape
+	keyCSI
+	keySS3
+)
+
+// lineEditor models an emacs-style line editor such as readline or zle to
+// reconstruct the command lines submitted through raw keystrokes.
+type lineEditor struct {
+	line   []rune
+	cursor int
+
+	state   keyState
+	params  []byte
+	pending []byte
+
+	pasting       bool
+	pasted        bool
+	historyRecall bool
+	completion    bool
+	uncertain     bool
+
+	history      []string
+	historyIndex int
+}
+
+// Feed processes keystrokes and calls submit for every line completed by Enter.
+// It returns the keystrokes to pass on to the slave: when submit returns false,
+// the Enter key is replaced by Ctrl-C so that the shell discards the line.
+func (e *lineEditor) Feed(data []byte, submit func(event *InputLineEvent) bool) []byte {
+	var (
+		chunk  = data
+		offset = len(e.pending)
+		vetoed []int
+	)
+
+	data = append(e.pending, data...)
+	e.pending = e.pending[:0]
+	total := len(data)
+
+	for len(data) > 0 {
+		b := data[0]
+
+		switch e.state {
+		case keyEscape:
+			data = data[1:]
+			e.escape(b)
+			continue
+		case keyCSI:
+			data = data[1:]
+			if b >= 0x40 && b <= 0x7e {
+				e.csi(string(e.params), b)
+				e.state = keyGround
+			} else {
+				e.params = append(e.params, b)
+			}
+			continue
+		case keySS3:
+			data = data[1:]
+			e.csi("", b)
+			e.state = keyGround
+			continue
+		}
+
+		if b >= 0x80 {
+			if !utf8.FullRune(data) {
+				e.pending = append(e.pending, data...)
+				break
+			}
+			r, size := utf8.DecodeRune(data)
+			data = data[size:]
+			e.insert(r)
+			continue
+		}
+
+		index := total - len(data) - offset
+		data = data[1:]
+		if !e.key(b, submit) {
+			vetoed = append(vetoed, index)
+		}
+	}
+
+	if len(vetoed) == 0 {
+		return chunk
+	}
+
+	forward := make([]byte, len(chunk))
+	copy(forward, chunk)
+	for _, index := range vetoed {
+		forward[index] = 0x03
+	}
+	return forward
+}
+
+// key handles a single byte key and reports whether it should be passed on.
+func (e *lineEditor) key(b byte, submit func(event *InputLineEvent) bool) bool {
+	if e.pasting {
+		switch b {
+		case esc:
+			e.state = keyEscape
+		case '\r', '\n':
+			e.insert('\n')
+		default:
+			if b >= 0x20 || b == '\t' {
+				e.insert(rune(b))
+			}
+		}
+		return true
+	}
+
+	switch b {
+	case esc:
+		e.state = keyEscape
+	case '\r', '\n':
+		return e.submit(submit)
+	case 0x0f: // Ctrl-O, submits the line and recalls the next one from the history
+		if !e.submit(submit) {
+			return false
+		}
+		e.uncertain = true
+	case 0x01: // Ctrl-A
+		e.cursor = 0
+	case 0x02: // Ctrl-B
+		e.move(-1)
+	case 0x03: // Ctrl-C
+		e.reset()
+	case 0x04: // Ctrl-D
+		e.deleteForward(1)
+	case 0x05: // Ctrl-E
+		e.cursor = len(e.line)
+	case 0x06: // Ctrl-F
+		e.move(1)
+	case 0x08, 0x7f: // Backspace
+		e.deleteBackward(1)
+	case '\t':
+		e.completion = true
+		e.uncertain = true
+	case 0x0b: // Ctrl-K
+		e.line = e.line[:e.cursor]
+	case 0x0c: // Ctrl-L, clears the screen
+	case 0x0e: // Ctrl-N
+		e.recall(1)
+	case 0x10: // Ctrl-P
+		e.recall(-1)
+	case 0x15: // Ctrl-U
+		e.line = append(e.line[:0], e.line[e.cursor:]...)
+		e.cursor = 0
+	case 0x17: // Ctrl-W
+		e.deleteBackward(e.cursor - e.wordStart(unicode.IsSpace))
+	default:
+		if b >= 0x20 {
+			e.insert(rune(b))
+		} else {
+			// Searching, yanking, transposing, ... are not modelled.
+			e.uncertain = true
+		}
+	}
+	return true
+}
+
+// escape handles the byte following ESC: CSI and SS3 introducers or Alt-<key>.
+func (e *lineEditor) escape(b byte) {
+	e.state = keyGround
+
+	switch b {
+	case '[':
+		e.state = keyCSI
+		e.params = e.params[:0]
+	case 'O':
+		e.state = keySS3
+	case 'b': // Alt-b
+		e.cursor = e.wordStart(isWordSeparator)
+	case 'f': // Alt-f
+		e.cursor = e.wordEnd()
+	case 'd': // Alt-d
+		e.deleteForward(e.wordEnd() - e.cursor)
+	case 0x7f, 0x08: // Alt-Backspace
+		e.deleteBackward(e.cursor - e.wordStart(isWordSeparator))
+	default:
+		if !e.pasting {
+			e.uncertain = true
+		}
+	}
+}
+
+func (e *lineEditor) csi(params string, final byte) {
+	switch {
+	case final == '~' && params == "200":
+		e.pasting = true
+		e.pasted = true
+	case final == '~' && params == "201":
+		e.pasting = false
+	case e.pasting:
+		// keys inside a paste are literal text
+	case final == 'A':
+		e.recall(-1)
+	case final == 'B':
+		e.recall(1)
+	case final == 'C':
+		if strings.HasSuffix(params, ";5") || strings.HasSuffix(params, ";3") {
+			e.cursor = e.wordEnd()
+		} else {
+			e.move(1)
+		}
+	case final == 'D':
+		if strings.HasSuffix(params, ";5") || strings.HasSuffix(params, ";3") {
+			e.cursor = e.wordStart(isWordSeparator)
+		} else {
+			e.move(-1)
+		}
+	case final == 'H' || (final == '~' && (params == "1" || params == "7")):
+		e.cursor = 0
+	case final == 'F' || (final == '~' && (params == "4" || params == "8")):
+		e.cursor = len(e.line)
+	case final == '~' && params == "3":
+		e.deleteForward(1)
+	}
+}
+
+func (e *lineEditor) submit(submit func(event *InputLineEvent) bool) bool {
+	line := string(e.line)
+	event := &InputLineEvent{
+		AuditHeader:   AuditHeader{Type: "input_line", Time: time.Now()},
+		Line:          line,
+		Pasted:        e.pasted,
+		HistoryRecall: e.historyRecall,
+		Completion:    e.completion,
+		Uncertain:     e.uncertain,
+	}
+
+	if strings.TrimSpace(line) != "" {
+		e.history = append(e.history, line)
+		if len(e.history) > maxLineEditorHistory {
+			e.history = e.history[1:]
+		}
+	}
+	e.reset()
+
+	return submit(event)
+}
+
+// maxLineEditorHistory is the number of lines kept for history recall.
+const maxLineEditorHistory = 1000
+
+func (e *lineEditor) reset() {
+	e.line = e.line[:0]
+	e.cursor = 0
+	e.pasted = false
+	e.historyRecall = false
+	e.completion = false
+	e.uncertain = false
+	e.historyIndex = len(e.history)
+}
+
+func (e *lineEditor) recall(delta int) {
+	index := e.historyIndex + delta
+	if index < 0 {
+		// The shell recalls lines from before the session.
+		e.uncertain = true
+		return
+	}
+	if index > len(e.history) {
+		return
+	}
+	e.historyIndex = index
+
+	if index == len(e.history) {
+		e.line = e.line[:0]
+	} else {
+		e.line = append(e.line[:0], []rune(e.history[index])...)
+		e.historyRecall = true
+	}
+	e.cursor = len(e.line)
+}
+
+func (e *lineEditor) insert(r rune) {
+	e.line = append(e.line, 0)
+	copy(e.line[e.cursor+1:], e.line[e.cursor:])
+	e.line[e.cursor] = r
+	e.cursor++
+}
+
+func (e *lineEditor) move(delta int) {
+	e.cursor += delta
+	if e.cursor < 0 {
+		e.cursor = 0
+	} else if e.cursor > len(e.line) {
+		e.cursor = len(e.line)
+	}
+}
+
+func (e *lineEditor) deleteBackward(n int) {
+	if n > e.cursor {
+		n = e.cursor
+	}
+	if n <= 0 {
+		return
+	}
+	e.line = append(e.line[:e.cursor-n], e.line[e.cursor:]...)
+	e.cursor -= n
+}
+
+func (e *lineEditor) deleteForward(n int) {
+	if e.cursor+n > len(e.line) {
+		n = len(e.line) - e.cursor
+	}
+	if n <= 0 {
+		return
+	}
+	e.line = append(e.line[:e.cursor], e.line[e.cursor+n:]...)
+}
+
+// wordStart returns the start of the word before the cursor.
+func (e *lineEditor) wordStart(separator func(r rune) bool) int {
+	i := e.cursor
+	for i > 0 && separator(e.line[i-1]) {
+		i--
+	}
+	for i > 0 && !separator(e.line[i-1]) {
+		i--
+	}
+	return i
+}
+
+// wordEnd returns the end of the word after the cursor.
+func (e *lineEditor) wordEnd() int {
+	i := e.cursor
+	for i < len(e.line) && isWordSeparator(e.line[i]) {
+		i++
+	}
+	for i < len(e.line) && !isWordSeparator(e.line[i]) {
+		i++
+	}
+	return i
+}
+
+func isWordSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
@@ -0,0 +1,80 @@
+package tty
+
+import (
+	"testing"
+)
+
+func TestLineEditor(t *testing.T) {
+	for _, test := range []struct {
+		Name  string
+		Input []string
+		Want  []string
+	}{
+		{"plain", []string{"ls -la\r"}, []string{"ls -la"}},
+		{"backspace", []string{"lss\x7f -l\r"}, []string{"ls -l"}},
+		{"ctrl-w", []string{"rm -rf /tmp\x17/var\r"}, []string{"rm -rf /var"}},
+		{"ctrl-u", []string{"whoami\x15id\r"}, []string{"id"}},
+		{"arrows", []string{"echo wrld\x1b[D\x1b", "[D\x1b[Do\x1b[C\r"}, []string{"echo world"}},
+		{"ss3 arrows", []string{"cd tmp\x1bOH/\r"}, []string{"/cd tmp"}},
+		{"history", []string{"uptime\r", "\x1b[A\r"}, []string{"uptime", "uptime"}},
+		{"paste", []string{"\x1b[200~echo a\recho b\x1b[201~\r"}, []string{"echo a\necho b"}},
+		{"utf8", []string{"echo \xc3", "\xa9t\xc3\xa9\r"}, []string{"echo été"}},
+		{"ctrl-c", []string{"reboot\x03\r"}, []string{""}},
+	} {
+		t.Run(test.Name, func(t *testing.T) {
+			var (
+				editor lineEditor
+				lines  []string
+			)
+			for _, chunk := range test.Input {
+				editor.Feed([]byte(chunk), func(event *InputLineEvent) bool {
+					lines = append(lines, event.Line)
+					return true
+				})
+			}
+			if len(lines) != len(test.Want) {
+				t.Fatalf("expected %d lines, got %q", len(test.Want), lines)
+			}
+			for i := range lines {
+				if lines[i] != test.Want[i] {
+					t.Errorf("expected line %q, got %q", test.Want[i], lines[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLineEditor_Uncertain(t *testing.T) {
+	for _, test := range []struct {
+		Name  string
+		Input string
+		Want  bool
+	}{
+		{"plain", "ls -la\r", false},
+		{"history", "uptime\r\x1b[A\r", false},
+		{"paste", "\x1b[200~echo\ta\x1b[201~\r", false},
+		{"tab", "reb\toot\r", true},
+		{"ctrl-r", "\x12boot\r", true},
+		{"ctrl-o", "id\x0f\r", true},
+		{"alt-key", "ls\x1b.\r", true},
+		{"history before the session", "\x1b[A\r", true},
+		{"reset", "reb\t\x03id\r", false},
+	} {
+		t.Run(test.Name, func(t *testing.T) {
+			var (
+				editor lineEditor
+				last   *InputLineEvent
+			)
+			editor.Feed([]byte(test.Input), func(event *InputLineEvent) bool {
+				last = event
+				return true
+			})
+			if last == nil {
+				t.Fatal("expected a line")
+			}
+			if last.Uncertain != test.Want {
+				t.Errorf("expected uncertain %v for %q", test.Want, last.Line)
+			}
+		})
+	}
+}
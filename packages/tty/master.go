@@ -2,6 +2,147 @@ package tty
 
 import (
 	"io"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
-type Master io.ReadWriter
+// Master is a terminal attached to the session. All masters get the output
+// of the slave, and the input of the ones with PermitWrite is merged to it.
+type Master struct {
+	// Name identifies the participant in the audit log.
+	Name        string
+	Stdout      io.Writer
+	PermitWrite bool
+}
+
+// Resizer is implemented by the Stdout of masters that want to know
+// about the terminal size of the session.
+type Resizer interface {
+	Resize(size TerminalSize)
+}
+
+// ParticipantEvent records a master attaching to or leaving the session.
+type ParticipantEvent struct {
+	AuditHeader
+
+	Participant string `json:"participant"`
+	PermitWrite bool   `json:"permit_write"`
+}
+
+// InputTurnEvent is recorded whenever input comes from a different
+// participant than before, attributing the keystrokes that follow.
+type InputTurnEvent struct {
+	AuditHeader
+
+	Participant string        `json:"participant"`
+	Frame       FramePosition `json:"frame"`
+}
+
+// WithOwner names the master passed to New in the audit log.
+func WithOwner(name string) Option {
+	return func(ptty *ProxyTTY) error {
+		ptty.owner.Name = name
+		return nil
+	}
+}
+
+// Attach adds m to the session. When replay is set, m first gets the current
+// size and screen of the session, see WithWatchSocket.
+func (ptty *ProxyTTY) Attach(m *Master, replay bool) {
+	ptty.writeMutex.Lock()
+	if replay {
+		if resizer, ok := m.Stdout.(Resizer); ok {
+			resizer.Resize(TerminalSize{Columns: ptty.columns, Rows: ptty.rows})
+		}
+		if ptty.screen != nil {
+			m.Stdout.Write(ptty.screen.Replay())
+		}
+	}
+	ptty.masters = append(ptty.masters, m)
+	ptty.writeMutex.Unlock()
+
+	ptty.audit(&ParticipantEvent{
+		AuditHeader: AuditHeader{Type: "participant_attach", Time: time.Now()},
+		Participant: m.Name,
+		PermitWrite: m.PermitWrite,
+	})
+}
+
+// Detach removes m from the session. It is safe to call several times.
+func (ptty *ProxyTTY) Detach(m *Master) {
+	ptty.writeMutex.Lock()
+	detached := ptty.removeMaster(m)
+	ptty.writeMutex.Unlock()
+
+	if detached {
+		ptty.audit(&ParticipantEvent{
+			AuditHeader: AuditHeader{Type: "participant_detach", Time: time.Now()},
+			Participant: m.Name,
+			PermitWrite: m.PermitWrite,
+		})
+	}
+}
+
+// removeMaster must be called with writeMutex held.
+func (ptty *ProxyTTY) removeMaster(m *Master) bool {
+	for i, master := range ptty.masters {
+		if master == m {
+			ptty.masters = append(ptty.masters[:i], ptty.masters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Input passes input from m on to the slave, if m is permitted to write.
+func (ptty *ProxyTTY) Input(m *Master, buf []byte) error {
+	return ptty.handleMasterReadEvent(m, buf)
+}
+
+// masterWrite writes data to every master. Failing masters other than the
+// owner are detached, a failing owner is an error.
+func (ptty *ProxyTTY) masterWrite(data []byte) error {
+	ptty.writeMutex.Lock()
+	defer ptty.writeMutex.Unlock()
+
+	return ptty.writeMasters(data)
+}
+
+// writeMasters must be called with writeMutex held.
+func (ptty *ProxyTTY) writeMasters(data []byte) error {
+	var failed []*Master
+	for _, m := range ptty.masters {
+		if _, err := m.Stdout.Write(data); err != nil {
+			if m == ptty.owner {
+				return errors.Wrapf(err, "failed to write to master")
+			}
+			failed = append(failed, m)
+		}
+	}
+
+	for _, m := range failed {
+		ptty.removeMaster(m)
+		ptty.audit(&ParticipantEvent{
+			AuditHeader: AuditHeader{Type: "participant_detach", Time: time.Now()},
+			Participant: m.Name,
+			PermitWrite: m.PermitWrite,
+		})
+	}
+
+	return nil
+}
+
+// resizeMasters tells the masters about a new terminal size of the session.
+func (ptty *ProxyTTY) resizeMasters(size TerminalSize) {
+	ptty.writeMutex.Lock()
+	defer ptty.writeMutex.Unlock()
+
+	ptty.columns = size.Columns
+	ptty.rows = size.Rows
+	for _, m := range ptty.masters {
+		if resizer, ok := m.Stdout.(Resizer); ok {
+			resizer.Resize(size)
+		}
+	}
+}
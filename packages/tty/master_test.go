@@ -0,0 +1,218 @@
+package tty
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testMaster is the Stdout of a master, failing writes once broken.
+type testMaster struct {
+	bytes.Buffer
+	sizes  []TerminalSize
+	broken bool
+}
+
+func (m *testMaster) Write(data []byte) (int, error) {
+	if m.broken {
+		return 0, errors.New("connection lost")
+	}
+	return m.Buffer.Write(data)
+}
+
+func (m *testMaster) Resize(size TerminalSize) {
+	m.sizes = append(m.sizes, size)
+}
+
+// participantEvents collects the participant and input turn events of a session.
+func participantEvents(events *[]string) Option {
+	return WithObserver(ObserverFunc(func(event Event) error {
+		switch e := event.(type) {
+		case *ParticipantEvent:
+			*events = append(*events, e.Type+" "+e.Participant)
+		case *InputTurnEvent:
+			*events = append(*events, e.Type+" "+e.Participant)
+		}
+		return nil
+	}))
+}
+
+func TestProxyTTY_InputActivity(t *testing.T) {
+	tests := []struct {
+		name        string
+		permitWrite bool
+		frozen      bool
+		wantTouched bool
+	}{
+		{name: "writer", permitWrite: true, wantTouched: true},
+		{name: "watcher", permitWrite: false},
+		{name: "frozen writer", permitWrite: true, frozen: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ptty, err := New(nil, &bytes.Buffer{}, &testSlave{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			idleSince := time.Now().Add(-time.Hour)
+			ptty.lastPingTime = idleSince
+			ptty.frozen = tt.frozen
+
+			ptty.Input(&Master{Name: "bob", PermitWrite: tt.permitWrite}, []byte("x"))
+			if touched := ptty.lastPingTime != idleSince; touched != tt.wantTouched {
+				t.Errorf("activity recorded %v, want %v", touched, tt.wantTouched)
+			}
+		})
+	}
+}
+
+func TestProxyTTY_AttachDetach(t *testing.T) {
+	tests := []struct {
+		name       string
+		replay     bool
+		wantOutput string
+		wantSizes  []TerminalSize
+	}{
+		{name: "live only", wantOutput: "live"},
+		{name: "replay", replay: true, wantOutput: string(clearScreen) + "screenlive", wantSizes: []TerminalSize{{Columns: 80, Rows: 24}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []string
+			ptty, err := New(nil, &bytes.Buffer{}, &testSlave{}, participantEvents(&events))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ptty.screen = &screenBuffer{}
+			ptty.screen.Write([]byte("screen"))
+			ptty.resizeMasters(TerminalSize{Columns: 80, Rows: 24})
+
+			stdout := &testMaster{}
+			m := &Master{Name: "bob", Stdout: stdout}
+			ptty.Attach(m, tt.replay)
+			ptty.masterWrite([]byte("live"))
+			ptty.Detach(m)
+			ptty.Detach(m)
+			ptty.masterWrite([]byte("after"))
+
+			if stdout.String() != tt.wantOutput {
+				t.Errorf("master got %q, want %q", stdout.String(), tt.wantOutput)
+			}
+			if !reflect.DeepEqual(stdout.sizes, tt.wantSizes) {
+				t.Errorf("master got sizes %v, want %v", stdout.sizes, tt.wantSizes)
+			}
+			want := []string{"participant_attach bob", "participant_detach bob"}
+			if !reflect.DeepEqual(events, want) {
+				t.Errorf("got events %q, want %q", events, want)
+			}
+		})
+	}
+}
+
+func TestProxyTTY_MergedInput(t *testing.T) {
+	type input struct {
+		master string
+		data   string
+	}
+	tests := []struct {
+		name       string
+		inputs     []input
+		wantSlave  string
+		wantEvents []string
+	}{
+		{
+			name:      "owner alone",
+			inputs:    []input{{"owner", "ls"}, {"owner", "\r"}},
+			wantSlave: "ls\r",
+		},
+		{
+			name:       "turns",
+			inputs:     []input{{"owner", "ec"}, {"bob", "ho"}, {"bob", " hi"}, {"owner", "\r"}},
+			wantSlave:  "echo hi\r",
+			wantEvents: []string{"input_turn bob", "input_turn owner"},
+		},
+		{
+			name:       "participant first",
+			inputs:     []input{{"bob", "id\r"}},
+			wantSlave:  "id\r",
+			wantEvents: []string{"input_turn bob"},
+		},
+		{
+			name:      "watcher ignored",
+			inputs:    []input{{"owner", "l"}, {"eve", "rm"}, {"owner", "s"}},
+			wantSlave: "ls",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []string
+			slave := &testSlave{}
+			ptty, err := New(nil, &bytes.Buffer{}, slave, WithPermitWrite(), participantEvents(&events))
+			if err != nil {
+				t.Fatal(err)
+			}
+			masters := map[string]*Master{
+				"owner": ptty.owner,
+				"bob":   {Name: "bob", PermitWrite: true},
+				"eve":   {Name: "eve"},
+			}
+
+			for _, in := range tt.inputs {
+				if err := ptty.Input(masters[in.master], []byte(in.data)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if slave.String() != tt.wantSlave {
+				t.Errorf("slave got %q, want %q", slave.String(), tt.wantSlave)
+			}
+			if !reflect.DeepEqual(events, tt.wantEvents) {
+				t.Errorf("got events %q, want %q", events, tt.wantEvents)
+			}
+		})
+	}
+}
+
+func TestProxyTTY_WriteMastersFailure(t *testing.T) {
+	tests := []struct {
+		name       string
+		breakOwner bool
+		wantErr    bool
+		wantEvents []string
+	}{
+		{name: "participant", wantEvents: []string{"participant_attach bob", "participant_detach bob"}},
+		{name: "owner", breakOwner: true, wantErr: true, wantEvents: []string{"participant_attach bob"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []string
+			owner := &testMaster{broken: tt.breakOwner}
+			ptty, err := New(nil, owner, &testSlave{}, participantEvents(&events))
+			if err != nil {
+				t.Fatal(err)
+			}
+			bob := &testMaster{broken: !tt.breakOwner}
+			ptty.Attach(&Master{Name: "bob", Stdout: bob}, false)
+
+			if err := ptty.masterWrite([]byte("out")); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(events, tt.wantEvents) {
+				t.Errorf("got events %q, want %q", events, tt.wantEvents)
+			}
+
+			if !tt.breakOwner {
+				ptty.masterWrite([]byte("more"))
+				if owner.String() != "outmore" || len(ptty.masters) != 1 {
+					t.Errorf("owner got %q with %d masters left", owner.String(), len(ptty.masters))
+				}
+			}
+		})
+	}
+}
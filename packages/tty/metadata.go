@@ -0,0 +1,118 @@
+package tty
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TerminalSize is the size of a terminal in character cells.
+type TerminalSize struct {
+	Columns int `json:"columns"`
+	Rows    int `json:"rows"`
+}
+
+// Metadata describes a recorded session. The Recorder writes it as JSON
+// next to the ttyrec and CSV files of every segment.
+type Metadata struct {
+	SessionID   string       `json:"session_id"`
+	User        string       `json:"user"`
+	UID         string       `json:"uid"`
+	Host        string       `json:"host"`
+	Shell       string       `json:"shell"`
+	Argv        []string     `json:"argv"`
+	Term        string       `json:"term"`
+	InitialSize TerminalSize `json:"initial_size"`
+	Version     string       `json:"version"`
+
+	// BannerSHA256 is the hash of the login banner the user accepted.
+	BannerSHA256     string     `json:"banner_sha256,omitempty"`
+	BannerAcceptedAt *time.Time `json:"banner_accepted_at,omitempty"`
+
+	// Reason and Ticket justify why the session was opened.
+	Reason string `json:"reason,omitempty"`
+	Ticket string `json:"ticket,omitempty"`
+
+	// Approver is the second person who approved the session, if required.
+	Approver   string     `json:"approver,omitempty"`
+	ApprovedAt *time.Time `json:"approved_at,omitempty"`
+
+	StartedAt  time.Time  `json:"started_at"`
+	EndedAt    *time.Time `json:"ended_at,omitempty"`
+	ExitStatus *int       `json:"exit_status,omitempty"`
+	// TerminationReason is set when qudosh ended the session itself.
+	TerminationReason string `json:"termination_reason,omitempty"`
+
+	// Segment is the index of the segment the document belongs to.
+	Segment int `json:"segment"`
+	// Incomplete is set while the segment is being recorded. Documents left
+	// with it describe files interrupted by a crash, see incompleteSuffix.
+	Incomplete bool `json:"incomplete,omitempty"`
+}
+
+// WithMetadata makes the Recorder write m as a JSON sidecar of every segment.
+// StartedAt is filled in by the Recorder when it is not set.
+func WithMetadata(m *Metadata) RecorderOption {
+	return func(r *Recorder) {
+		r.metadata = m
+	}
+}
+
+// UpdateMetadata applies fn to the session metadata. It is a no-op
+// when the Recorder was created without WithMetadata.
+func (r *Recorder) UpdateMetadata(fn func(m *Metadata)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.metadata != nil {
+		fn(r.metadata)
+	}
+}
+
+// Metadata returns a copy of the session metadata, or nil when the Recorder
+// was created without WithMetadata.
+func (r *Recorder) Metadata() *Metadata {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.metadata == nil {
+		return nil
+	}
+	m := *r.metadata
+	return &m
+}
+
+// encodeMetadata returns the metadata sidecar of s, or nil when the Recorder
+// was created without WithMetadata. It must be called with mu held.
+func (r *Recorder) encodeMetadata(s *segment) ([]byte, error) {
+	if r.metadata == nil {
+		return nil, nil
+	}
+
+	m := *r.metadata
+	m.Segment = s.Index
+	m.Incomplete = s.EndedAt.IsZero()
+
+	data, err := json.MarshalIndent(&m, "", "  ")
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encode metadata")
+	}
+	return append(data, '\n'), nil
+}
+
+// writeMetadata replaces the metadata sidecar of the segment with data.
+func (s *segment) writeMetadata(data []byte) error {
+	// The document is replaced at the end of the segment, atomically.
+	name := fmt.Sprintf("%s/%s.json", s.FilePrefix, s.FileName)
+	if err := os.WriteFile(name+".tmp", data, 0o644); err != nil {
+		return errors.Wrapf(err, "error writing %s", name)
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		return errors.Wrapf(err, "error writing %s", name)
+	}
+
+	return nil
+}
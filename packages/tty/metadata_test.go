@@ -0,0 +1,91 @@
+package tty
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRecorder_Metadata(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		mu       sync.Mutex
+		uploaded []Segment
+	)
+	upload := func(s *Segment) error {
+		mu.Lock()
+		defer mu.Unlock()
+		uploaded = append(uploaded, *s)
+		return nil
+	}
+
+	startedAt := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	r, err := newRecorder(context.Background(), dir, "session.ttyrec", upload,
+		RotateBySize(8),
+		WithMetadata(&Metadata{SessionID: "s1", User: "alice", Host: "db1", StartedAt: startedAt}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{"0123456789", "abcdefghij"} {
+		if _, err = r.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.UpdateMetadata(func(m *Metadata) {
+		status := 0
+		m.ExitStatus = &status
+	})
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Slice(uploaded, func(i, j int) bool { return uploaded[i].Index < uploaded[j].Index })
+	want := []string{"session.001.ttyrec", "session.002.ttyrec", "session.003.ttyrec"}
+	if len(uploaded) != len(want) {
+		t.Fatalf("uploaded %d segments, want %d", len(uploaded), len(want))
+	}
+
+	for i, s := range uploaded {
+		if s.FileName != want[i] {
+			t.Errorf("segment %d is %s, want %s", i+1, s.FileName, want[i])
+		}
+		if !containsString(s.Artifacts, s.FileName+".json") {
+			t.Errorf("%s lacks its metadata in %v", s.FileName, s.Artifacts)
+		}
+
+		m := readMetadata(t, filepath.Join(dir, s.FileName+".json"))
+		if m.SessionID != "s1" || m.User != "alice" || m.Host != "db1" {
+			t.Errorf("%s: got session %q of %s on %s", s.FileName, m.SessionID, m.User, m.Host)
+		}
+		if m.Segment != s.Index || m.Incomplete {
+			t.Errorf("%s: got segment %d, incomplete %v, want segment %d", s.FileName, m.Segment, m.Incomplete, s.Index)
+		}
+		if !m.StartedAt.Equal(startedAt) {
+			t.Errorf("%s: started at %s, want %s", s.FileName, m.StartedAt, startedAt)
+		}
+
+		// Only the document of the last segment describes the end of the session.
+		if !s.Final {
+			if m.EndedAt != nil || m.ExitStatus != nil {
+				t.Errorf("%s: rotated segment ended at %v with status %v", s.FileName, m.EndedAt, m.ExitStatus)
+			}
+			continue
+		}
+		if i != len(want)-1 {
+			t.Errorf("%s: final segment, want %s", s.FileName, want[len(want)-1])
+		}
+		if m.EndedAt == nil || !m.EndedAt.Equal(s.EndedAt) {
+			t.Errorf("%s: ended at %v, want %s", s.FileName, m.EndedAt, s.EndedAt)
+		}
+		if m.ExitStatus == nil || *m.ExitStatus != 0 {
+			t.Errorf("%s: got exit status %v, want 0", s.FileName, m.ExitStatus)
+		}
+	}
+	if !uploaded[len(uploaded)-1].Final {
+		t.Error("last segment not final")
+	}
+}
@@ -0,0 +1,166 @@
+package tty
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rcrowley/go-metrics"
+)
+
+// MetricsFormat is the format of the metrics file written next to every segment.
+type MetricsFormat string
+
+const (
+	// MetricsCSV writes semicolon separated values under a header naming the columns.
+	MetricsCSV MetricsFormat = "csv"
+	// MetricsJSONLines writes a JSON object per sample, after a header
+	// object describing the columns.
+	MetricsJSONLines MetricsFormat = "jsonl"
+)
+
+// ParseMetricsFormat parses the name of a MetricsFormat.
+func ParseMetricsFormat(s string) (MetricsFormat, error) {
+	switch format := MetricsFormat(s); format {
+	case MetricsCSV, MetricsJSONLines:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown metrics format %q", s)
+}
+
+// WithMetricsInterval samples the activity metrics every d instead of every
+// MetricsInterval. Durations that are not positive are ignored.
+func WithMetricsInterval(d time.Duration) RecorderOption {
+	return func(r *Recorder) {
+		if d > 0 {
+			r.metricsInterval = d
+		}
+	}
+}
+
+// WithMetricsFormat writes the activity metrics in format instead of CSV.
+func WithMetricsFormat(format MetricsFormat) RecorderOption {
+	return func(r *Recorder) {
+		r.metricsFormat = format
+	}
+}
+
+// MetricsColumn describes a column of the metrics file.
+type MetricsColumn struct {
+	Name        string `json:"name"`
+	Unit        string `json:"unit"`
+	Description string `json:"description"`
+}
+
+// MetricsColumns are the columns of the metrics file, in order. Events are
+// reads of the input or the output, whatever their size.
+var MetricsColumns = []MetricsColumn{
+	{"timestamp", "microseconds", "time of the sample since the epoch"},
+	{"stdin_events_delta", "events", "input events since the previous sample"},
+	{"stdin_events_total", "events", "input events since the start of the session"},
+	{"stdin_bytes_delta", "bytes", "input bytes since the previous sample"},
+	{"stdin_bytes_total", "bytes", "input bytes since the start of the session"},
+	{"stdout_events_delta", "events", "output events since the previous sample"},
+	{"stdout_events_total", "events", "output events since the start of the session"},
+	{"stdout_bytes_delta", "bytes", "output bytes since the previous sample"},
+	{"stdout_bytes_total", "bytes", "output bytes since the start of the session"},
+	{"dropped_bytes_delta", "bytes", "output bytes left out of the recording since the previous sample"},
+	{"dropped_bytes_total", "bytes", "output bytes left out of the recording since the start of the session"},
+}
+
+// metricsCounters are the totals sampled into the metrics file, in the order of MetricsColumns.
+type metricsCounters [5]int64
+
+func (r *Recorder) counters() metricsCounters {
+	return metricsCounters{
+		r.KeystrokesMeter.Count(),
+		r.InputBytesMeter.Count(),
+		r.OutputMeter.Count(),
+		r.OutputBytesMeter.Count(),
+		r.dropped.Count(),
+	}
+}
+
+// WithRegistry registers the meters of the Recorder in registry, so that they
+// can be published. They are named after the metrics columns, without their
+// _delta and _total suffixes: stdin_events, stdin_bytes, stdout_events,
+// stdout_bytes and dropped_bytes.
+func WithRegistry(registry metrics.Registry) RecorderOption {
+	return func(r *Recorder) {
+		meters := map[string]interface{}{
+			"stdin_events":  r.KeystrokesMeter,
+			"stdin_bytes":   r.InputBytesMeter,
+			"stdout_events": r.OutputMeter,
+			"stdout_bytes":  r.OutputBytesMeter,
+			"dropped_bytes": r.dropped,
+		}
+		for name, meter := range meters {
+			if err := registry.Register(name, meter); err != nil {
+				log.Print(errors.Wrapf(err, "failed to register %s", name))
+			}
+		}
+	}
+}
+
+// metricsSuffix is appended to the segment file name to name its metrics file.
+func (r *Recorder) metricsSuffix() string {
+	if r.metricsFormat == MetricsJSONLines {
+		return ".metrics.jsonl"
+	}
+	return ".csv"
+}
+
+// metricsHeader returns the lines a metrics file starts with.
+func (r *Recorder) metricsHeader() string {
+	if r.metricsFormat == MetricsJSONLines {
+		data, _ := json.Marshal(struct {
+			Interval string          `json:"interval"`
+			Columns  []MetricsColumn `json:"columns"`
+		}{r.metricsInterval.String(), MetricsColumns})
+		return string(data) + "\n"
+	}
+
+	// A comment line explains the columns named by the header line.
+	names := make([]string, len(MetricsColumns))
+	meanings := make([]string, len(MetricsColumns))
+	for i, column := range MetricsColumns {
+		names[i] = column.Name
+		meanings[i] = fmt.Sprintf("%s (%s): %s", column.Name, column.Unit, column.Description)
+	}
+	return "# " + strings.Join(meanings, "; ") + "\n" + strings.Join(names, ";") + "\n"
+}
+
+// metricsLine samples the counters into a line of the metrics file. It must be called with mu held.
+func (r *Recorder) metricsLine() string {
+	values := []int64{makeTimestamp()}
+	current := r.counters()
+	for i, total := range current {
+		values = append(values, total-r.sampled[i], total)
+	}
+	r.sampled = current
+
+	var b strings.Builder
+	for i, value := range values {
+		if r.metricsFormat == MetricsJSONLines {
+			if i == 0 {
+				b.WriteString("{")
+			} else {
+				b.WriteString(",")
+			}
+			b.WriteString(strconv.Quote(MetricsColumns[i].Name))
+			b.WriteString(":")
+		} else if i > 0 {
+			b.WriteString(";")
+		}
+		b.WriteString(strconv.FormatInt(value, 10))
+	}
+	if r.metricsFormat == MetricsJSONLines {
+		b.WriteString("}")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
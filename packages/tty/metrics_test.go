@@ -0,0 +1,112 @@
+package tty
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecorder_MetricsJSONLines(t *testing.T) {
+	dir := t.TempDir()
+	r, err := newRecorder(context.Background(), dir, "session.ttyrec", nil,
+		WithMetricsFormat(MetricsJSONLines), WithMetricsInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.OutputMeter.Mark(2)
+	r.OutputBytesMeter.Mark(4096)
+	r.KeystrokesMeter.Mark(1)
+	r.InputBytesMeter.Mark(3)
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "session.ttyrec.metrics.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var (
+		scanner = bufio.NewScanner(f)
+		header  struct {
+			Interval string          `json:"interval"`
+			Columns  []MetricsColumn `json:"columns"`
+		}
+		sample map[string]int64
+	)
+	if !scanner.Scan() {
+		t.Fatal("empty metrics file")
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		t.Fatal(err)
+	}
+	if header.Interval != "1h0m0s" || len(header.Columns) != len(MetricsColumns) {
+		t.Errorf("unexpected header %+v", header)
+	}
+	for scanner.Scan() {
+		sample = nil
+		if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string]int64{
+		"stdin_events_total":  1,
+		"stdin_bytes_total":   3,
+		"stdout_events_total": 2,
+		"stdout_bytes_delta":  4096,
+		"stdout_bytes_total":  4096,
+	}
+	for column, value := range want {
+		if sample[column] != value {
+			t.Errorf("%s: expected %d, got %d", column, value, sample[column])
+		}
+	}
+}
+
+func TestRecorder_MetricsCSV(t *testing.T) {
+	dir := t.TempDir()
+	r, err := newRecorder(context.Background(), dir, "session.ttyrec", nil, WithMetricsInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.KeystrokesMeter.Mark(1)
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "session.ttyrec.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected a comment, a header and 2 samples, got %q", lines)
+	}
+
+	comment, header := lines[0], strings.Split(lines[1], ";")
+	if !strings.HasPrefix(comment, "# timestamp (microseconds): time of the sample since the epoch; ") {
+		t.Errorf("unexpected comment %q", comment)
+	}
+	if len(header) != len(MetricsColumns) {
+		t.Fatalf("unexpected header %q", lines[1])
+	}
+	for i, column := range MetricsColumns {
+		if header[i] != column.Name {
+			t.Errorf("column %d: expected %s, got %s", i, column.Name, header[i])
+		}
+		if !strings.Contains(comment, column.Name+" ("+column.Unit+"): "+column.Description) {
+			t.Errorf("comment lacks the meaning of %s", column.Name)
+		}
+	}
+	if fields := strings.Split(lines[3], ";"); len(fields) != len(MetricsColumns) || fields[2] != "1" {
+		t.Errorf("unexpected last sample %q", lines[3])
+	}
+}
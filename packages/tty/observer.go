@@ -0,0 +1,159 @@
+package tty
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Event is passed to observers. Every event embeds AuditHeader, which
+// identifies it. Besides the audit events (commands, input lines, titles,
+// participants, ...), observers get the lifecycle events below.
+type Event interface {
+	EventType() string
+}
+
+// EventType returns the type of the event.
+func (h AuditHeader) EventType() string {
+	return h.Type
+}
+
+// Observer is notified of the events of a session, see WithObserver.
+type Observer interface {
+	// Observe is called synchronously from the goroutines of the session,
+	// so it should not block. As these run concurrently, so may the calls of
+	// Observe, which has to synchronise access to its own state. The Recorder
+	// does not hold its lock while delivering its events. Data of the event is
+	// only valid during the call. An error does not stop the session, it is
+	// returned by Run.
+	Observe(event Event) error
+}
+
+// ObserverFunc is an Observer calling a function.
+type ObserverFunc func(event Event) error
+
+// Observe calls f(event).
+func (f ObserverFunc) Observe(event Event) error {
+	return f(event)
+}
+
+// SessionStartEvent is observed when Run starts.
+type SessionStartEvent struct {
+	AuditHeader
+
+	// Metadata is a copy of the session metadata, if it is recorded.
+	Metadata *Metadata `json:"metadata,omitempty"`
+}
+
+// ResizeEvent is observed whenever the session is resized.
+type ResizeEvent struct {
+	AuditHeader
+
+	Size TerminalSize `json:"size"`
+}
+
+// OutputEvent is observed for every chunk of slave output that passed the filters.
+type OutputEvent struct {
+	AuditHeader
+
+	// Live is the output written to the masters, Recorded the output
+	// written to the recording at Frame, see WithOutputFilter.
+	Live     []byte        `json:"-"`
+	Recorded []byte        `json:"-"`
+	Frame    FramePosition `json:"frame"`
+}
+
+// InputEvent is observed for every chunk of input passed on to the slave.
+type InputEvent struct {
+	AuditHeader
+
+	Participant string `json:"participant"`
+	Data        []byte `json:"-"`
+}
+
+// SlaveExitEvent is observed when the slave went away, once its exit status is known.
+type SlaveExitEvent struct {
+	AuditHeader
+
+	// ExitStatus is nil when the slave does not report it.
+	ExitStatus *int `json:"exit_status"`
+}
+
+// SegmentFinalizedEvent is observed when a segment of the recording is complete,
+// before it is uploaded.
+type SegmentFinalizedEvent struct {
+	AuditHeader
+
+	Segment Segment `json:"segment"`
+}
+
+// UploadFinishedEvent is observed when the Uploader returned for a segment.
+type UploadFinishedEvent struct {
+	AuditHeader
+
+	Segment Segment `json:"segment"`
+	Err     error   `json:"-"`
+}
+
+// SessionError is returned by Run when observers or uploads failed during
+// the session. It unwraps to the error the session ended with.
+type SessionError struct {
+	Err      error
+	Failures []error
+}
+
+func (e *SessionError) Error() string {
+	failures := make([]string, len(e.Failures))
+	for i, failure := range e.Failures {
+		failures[i] = failure.Error()
+	}
+
+	if e.Err == nil {
+		return strings.Join(failures, "; ")
+	}
+	return fmt.Sprintf("%s (%s)", e.Err, strings.Join(failures, "; "))
+}
+
+func (e *SessionError) Unwrap() error {
+	return e.Err
+}
+
+// WithObserver adds o to the observers of the session.
+func WithObserver(o Observer) Option {
+	return func(ptty *ProxyTTY) error {
+		ptty.observers = append(ptty.observers, o)
+		return nil
+	}
+}
+
+// failures collects the errors of observers and uploads.
+type failures struct {
+	mu     sync.Mutex
+	errors []error
+}
+
+func (f *failures) add(err error) {
+	f.mu.Lock()
+	f.errors = append(f.errors, err)
+	f.mu.Unlock()
+}
+
+// wrap returns err, wrapped in a SessionError if anything failed.
+func (f *failures) wrap(err error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.errors) == 0 {
+		return err
+	}
+	return &SessionError{Err: err, Failures: f.errors}
+}
+
+// observe passes event to the observers.
+func (ptty *ProxyTTY) observe(event Event) {
+	for _, o := range ptty.observers {
+		if err := o.Observe(event); err != nil {
+			ptty.failures.add(fmt.Errorf("observer failed on %s: %w", event.EventType(), err))
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package tty
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestProxyTTY_Observer(t *testing.T) {
+	slave := &testSlave{}
+	slave.WriteString("hello")
+
+	var types []string
+	observer := ObserverFunc(func(event Event) error {
+		types = append(types, event.EventType())
+		if output, ok := event.(*OutputEvent); ok && string(output.Recorded) != "hello" {
+			t.Errorf("got output %q, want %q", output.Recorded, "hello")
+		}
+		if event.EventType() == "segment_finalized" {
+			return errors.New("observer broke")
+		}
+		return nil
+	})
+	upload := func(s *Segment) error {
+		return errors.New("bucket gone")
+	}
+
+	ptty, err := New(nil, nil, slave,
+		WithTtyRecording(context.Background(), t.TempDir(), "session.ttyrec", upload),
+		WithObserver(observer),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ptty.Run(context.Background())
+	if !errors.Is(err, ErrSlaveClosed) {
+		t.Errorf("got error %v, want it to wrap %v", err, ErrSlaveClosed)
+	}
+	var sessionErr *SessionError
+	if !errors.As(err, &sessionErr) || len(sessionErr.Failures) != 2 {
+		t.Fatalf("got error %v, want the observer and upload failures", err)
+	}
+
+	want := []string{"session_start", "output", "slave_exit", "segment_finalized", "upload_finished"}
+	if len(types) != len(want) {
+		t.Fatalf("got events %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Errorf("got events %v, want %v", types, want)
+			break
+		}
+	}
+}
+
+func TestProxyTTY_ObserverUsesRecorder(t *testing.T) {
+	slave := &testSlave{}
+	slave.WriteString("hello")
+
+	var ptty *ProxyTTY
+	var ended []bool
+	observer := ObserverFunc(func(event Event) error {
+		switch event.(type) {
+		case *SegmentFinalizedEvent, *UploadFinishedEvent:
+			ended = append(ended, ptty.logger.Metadata().EndedAt != nil)
+			ptty.logger.UpdateMetadata(func(m *Metadata) {})
+		}
+		return nil
+	})
+	upload := func(s *Segment) error {
+		return nil
+	}
+
+	var err error
+	ptty, err = New(nil, nil, slave,
+		WithTtyRecording(context.Background(), t.TempDir(), "session.ttyrec", upload,
+			WithMetadata(&Metadata{SessionID: "s"})),
+		WithObserver(observer),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ptty.Run(context.Background())
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrSlaveClosed) {
+			t.Errorf("got error %v, want %v", err, ErrSlaveClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("observer deadlocked on the recorder")
+	}
+
+	if len(ended) != 2 || !ended[0] || !ended[1] {
+		t.Errorf("got ended %v, want the end time set for both events", ended)
+	}
+}
@@ -2,14 +2,7 @@ package tty
 
 import (
 	"context"
-	"fmt"
-	"log"
-	"os"
 	"time"
-
-	"github.com/pkg/errors"
-	"github.com/rcrowley/go-metrics"
-	"github.com/x-qdo/qudosh/packages/ttyrec"
 )
 
 const MetricsInterval = 1 * time.Second
@@ -24,100 +17,64 @@ type Option func(*ProxyTTY) error
 // WithPermitWrite sets a ProxyTTY to accept input from slaves.
 func WithPermitWrite() Option {
 	return func(ptty *ProxyTTY) error {
-		ptty.permitWrite = true
+		ptty.owner.PermitWrite = true
 		return nil
 	}
 }
 
-func WithTtyRecording(parent context.Context, filePrefix, fileName string, finishedHandler Hook) Option {
+// WithCommandAudit writes every command reported by the shell through
+// OSC 133 marks to the audit log of the recording.
+func WithCommandAudit() Option {
 	return func(ptty *ProxyTTY) error {
-		stdinCounter := metrics.NewMeter()
-		stdoutCounter := metrics.NewMeter()
-		f, err := os.Create(fmt.Sprintf("%s/%s", filePrefix, fileName))
-		if err != nil {
-			log.Print(errors.Wrapf(err, "error opening %s: %v\n", fileName, err))
-			return errors.Wrapf(err, "error opening %s: %v\n", fileName, err)
-		}
-
-		ctx, cancel := context.WithCancel(parent)
-
-		go func() error {
-			metricsFile, err := os.Create(fmt.Sprintf("%s/%s.csv", filePrefix, fileName))
-			if err != nil {
-				log.Print(errors.Wrapf(err, "error opening %s: %v\n", fileName, err))
-				return errors.Wrapf(err, "error opening %s: %v\n", fileName, err)
-			}
-
-			// write csv header
-			fmt.Fprintf(
-				metricsFile,
-				"timestamp;stdin_delta;stdin_total;stdout_delta;stdout_total\n",
-			)
-
-			defer func() {
-				// write the last line
-				fmt.Fprintf(
-					metricsFile,
-					"%d;%d;%d;%d;%d\n",
-					makeTimestamp(),
-					0,
-					stdinCounter.Count(),
-					0,
-					stdoutCounter.Count(),
-				)
-
-				stdinCounter.Stop()
-				stdoutCounter.Stop()
-				f.Close()
-				metricsFile.Close()
-			}()
-
-			go func() {
-				var stdinTotal int64 = 0
-				var stdoutTotal int64 = 0
-
-				writeLine := func() {
-					stdinDelta := stdinCounter.Count() - stdinTotal
-					stdinTotal = stdinCounter.Count()
-
-					stdoutDelta := stdoutCounter.Count() - stdoutTotal
-					stdoutTotal = stdoutCounter.Count()
-
-					fmt.Fprintf(
-						metricsFile,
-						"%d;%d;%d;%d;%d\n",
-						makeTimestamp(),
-						stdinDelta,
-						stdinTotal,
-						stdoutDelta,
-						stdoutTotal,
-					)
-				}
-
-				// write the first line
-				writeLine()
+		ptty.commands = &commandTracker{}
+		return nil
+	}
+}
 
-				for range time.Tick(MetricsInterval) {
-					writeLine()
-				}
-			}()
+// WithInputAudit reconstructs the command lines typed on the master from the
+// raw keystrokes and writes them to the audit log of the recording.
+// It is meant for shells without OSC 133 integration, see WithCommandAudit.
+func WithInputAudit() Option {
+	return func(ptty *ProxyTTY) error {
+		if ptty.lineEditor == nil {
+			ptty.lineEditor = &lineEditor{}
+		}
+		ptty.auditInput = true
+		return nil
+	}
+}
 
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			}
-		}()
+// WithCommandPolicy evaluates every line submitted on the master against policy
+// when Enter is pressed. Denied lines are discarded with a message on the master
+// instead of being submitted to the slave. Every decision is written to the
+// audit log of the recording.
+//
+// The lines are reconstructed from keystrokes, see WithInputAudit, which makes
+// the policy a safeguard against mistakes rather than a security boundary.
+func WithCommandPolicy(policy *Policy, user string) Option {
+	return func(ptty *ProxyTTY) error {
+		if ptty.lineEditor == nil {
+			ptty.lineEditor = &lineEditor{}
+		}
+		ptty.policy = policy
+		ptty.policyUser = user
+		return nil
+	}
+}
 
-		ptty.logger = &Recorder{
-			KeystrokesMeter: stdinCounter,
-			OutputMeter:     stdoutCounter,
-			FileName:        fileName,
-			FilePrefix:      filePrefix,
-			logger:          ttyrec.NewEncoder(f),
-			Hook:            finishedHandler,
-			Cancel:          cancel,
+// WithTtyRecording records the slave output to filePrefix/fileName in the ttyrec
+// format, along with activity metrics in a CSV file next to it.
+// Every completed segment is passed to upload, with the outcome observed as
+// an UploadFinishedEvent.
+func WithTtyRecording(parent context.Context, filePrefix, fileName string, upload Uploader, options ...RecorderOption) Option {
+	return func(ptty *ProxyTTY) error {
+		recorder, err := newRecorder(parent, filePrefix, fileName, upload, options...)
+		if err != nil {
+			return err
 		}
 
+		recorder.events = ptty.recorderEvent
+		ptty.logger = recorder
 		return nil
 	}
 }
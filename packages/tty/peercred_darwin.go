@@ -0,0 +1,38 @@
+package tty
+
+import (
+	"net"
+	"os/user"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerUser returns the name of the user on the other end of a Unix socket.
+func peerUser(conn net.Conn) (string, bool) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return "", false
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return "", false
+	}
+
+	var (
+		cred    *unix.Xucred
+		credErr error
+	)
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	})
+	if err != nil || credErr != nil {
+		return "", false
+	}
+
+	uid := strconv.Itoa(int(cred.Uid))
+	if u, err := user.LookupId(uid); err == nil {
+		return u.Username, true
+	}
+	return uid, true
+}
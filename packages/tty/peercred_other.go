@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package tty
+
+import (
+	"net"
+)
+
+// peerUser is not supported on this platform, the user of the Hello is used instead.
+func peerUser(conn net.Conn) (string, bool) {
+	return "", false
+}
@@ -0,0 +1,202 @@
+package tty
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PolicyAction is the outcome of a policy rule.
+type PolicyAction string
+
+const (
+	PolicyAllow PolicyAction = "allow"
+	PolicyDeny  PolicyAction = "deny"
+)
+
+// PolicyRule matches command lines by regular expression and/or prefix.
+// A rule with both set only matches when both do.
+type PolicyRule struct {
+	Action PolicyAction `json:"action"`
+	Regex  string       `json:"regex,omitempty"`
+	Prefix string       `json:"prefix,omitempty"`
+	// Users restricts the rule to the listed users, it applies to everybody when empty.
+	Users []string `json:"users,omitempty"`
+	// Message is shown to the user when the rule denies a command.
+	Message string `json:"message,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// Policy is an ordered list of rules, the first matching rule decides.
+// Commands not matched by any rule get the Default action, allow if unset.
+type Policy struct {
+	Rules   []PolicyRule `json:"rules"`
+	Default PolicyAction `json:"default,omitempty"`
+}
+
+// PolicyDecision is the result of evaluating a command line.
+type PolicyDecision struct {
+	Action PolicyAction
+	// Rule is the index of the matching rule, or -1 for the default action.
+	Rule    int
+	Message string
+}
+
+// PolicyEvent records a policy decision in the audit log.
+type PolicyEvent struct {
+	AuditHeader
+
+	User    string        `json:"user"`
+	Line    string        `json:"line"`
+	Action  PolicyAction  `json:"action"`
+	Rule    int           `json:"rule"`
+	Message string        `json:"message,omitempty"`
+	Frame   FramePosition `json:"frame"`
+}
+
+// LoadPolicy reads a JSON policy from path.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read policy %s", path)
+	}
+
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse policy %s", path)
+	}
+
+	if err := policy.Compile(); err != nil {
+		return nil, err
+	}
+
+	return &policy, nil
+}
+
+// Compile validates the policy and compiles its regular expressions.
+// It has to be called before Evaluate on policies not created by LoadPolicy.
+func (p *Policy) Compile() error {
+	switch p.Default {
+	case "":
+		p.Default = PolicyAllow
+	case PolicyAllow, PolicyDeny:
+	default:
+		return errors.Errorf("invalid default policy action %q", p.Default)
+	}
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Action != PolicyAllow && rule.Action != PolicyDeny {
+			return errors.Errorf("rule %d: invalid action %q", i, rule.Action)
+		}
+		if rule.Regex == "" && rule.Prefix == "" {
+			return errors.Errorf("rule %d: either regex or prefix is required", i)
+		}
+		if rule.Regex != "" {
+			re, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return errors.Wrapf(err, "rule %d: invalid regex", i)
+			}
+			rule.re = re
+		}
+	}
+
+	return nil
+}
+
+// Evaluate decides whether user may run line. Whitespace in the line is
+// normalised before matching, so "rm  -rf  /" matches the prefix "rm -rf /".
+func (p *Policy) Evaluate(user, line string) PolicyDecision {
+	normalised := strings.Join(strings.Fields(line), " ")
+
+	for i, rule := range p.Rules {
+		if !rule.appliesTo(user) {
+			continue
+		}
+		if rule.Prefix != "" && !strings.HasPrefix(normalised, rule.Prefix) {
+			continue
+		}
+		if rule.re != nil && !rule.re.MatchString(normalised) {
+			continue
+		}
+		return PolicyDecision{Action: rule.Action, Rule: i, Message: rule.Message}
+	}
+
+	return PolicyDecision{Action: p.Default, Rule: -1}
+}
+
+func (r *PolicyRule) appliesTo(user string) bool {
+	if len(r.Users) == 0 {
+		return true
+	}
+	for _, u := range r.Users {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPolicy evaluates a submitted line and reports whether it may be passed on to the slave.
+// Every participant who typed part of the line has to be allowed to run it,
+// the owner being evaluated as the policy user. Lines the editor could not
+// reconstruct are denied, the submitted line may not be the one evaluated.
+func (ptty *ProxyTTY) checkPolicy(event *InputLineEvent) bool {
+	if strings.TrimSpace(event.Line) == "" && !event.Uncertain {
+		return true
+	}
+
+	users := []string{ptty.policyUser}
+	if len(event.Participants) > 0 {
+		users = users[:0]
+		for _, participant := range event.Participants {
+			if participant == ptty.owner.Name {
+				participant = ptty.policyUser
+			}
+			users = append(users, participant)
+		}
+	}
+
+	var (
+		decision PolicyDecision
+		user     string
+	)
+	if event.Uncertain {
+		decision = PolicyDecision{Action: PolicyDeny, Rule: -1, Message: "the line could not be reconstructed from the keystrokes"}
+		user = users[0]
+	} else {
+		for i, u := range users {
+			d := ptty.policy.Evaluate(u, event.Line)
+			if i == 0 || d.Action == PolicyDeny && decision.Action != PolicyDeny {
+				decision, user = d, u
+			}
+		}
+	}
+
+	ptty.audit(&PolicyEvent{
+		AuditHeader: AuditHeader{Type: "policy", Time: time.Now()},
+		User:        user,
+		Line:        event.Line,
+		Action:      decision.Action,
+		Rule:        decision.Rule,
+		Message:     decision.Message,
+		Frame:       event.Frame,
+	})
+
+	if decision.Action == PolicyAllow {
+		return true
+	}
+
+	message := "command blocked by policy"
+	if decision.Message != "" {
+		message += ": " + decision.Message
+	}
+	ptty.masterWrite([]byte("\r\nqudosh: " + message + "\r\n"))
+
+	return false
+}
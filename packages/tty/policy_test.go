@@ -0,0 +1,167 @@
+package tty
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPolicy_Evaluate(t *testing.T) {
+	policy := &Policy{
+		Rules: []PolicyRule{
+			{Action: PolicyAllow, Prefix: "kubectl delete ns sandbox-", Users: []string{"alice"}},
+			{Action: PolicyDeny, Prefix: "kubectl delete ns"},
+			{Action: PolicyDeny, Regex: `^rm (-[a-zA-Z]*[rf][a-zA-Z]* )+/+( |$)`},
+		},
+	}
+	if err := policy.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		User, Line string
+		Want       PolicyAction
+		Rule       int
+	}{
+		{"bob", "ls -la", PolicyAllow, -1},
+		{"bob", "kubectl  delete   ns prod", PolicyDeny, 1},
+		{"alice", "kubectl delete ns sandbox-1", PolicyAllow, 0},
+		{"bob", "kubectl delete ns sandbox-1", PolicyDeny, 1},
+		{"bob", "rm -rf /", PolicyDeny, 2},
+		{"bob", "rm -r -f //", PolicyDeny, 2},
+		{"bob", "rm -rf /tmp/build", PolicyAllow, -1},
+	} {
+		t.Run(test.Line, func(t *testing.T) {
+			decision := policy.Evaluate(test.User, test.Line)
+			if decision.Action != test.Want || decision.Rule != test.Rule {
+				t.Errorf("expected %s by rule %d, got %s by rule %d", test.Want, test.Rule, decision.Action, decision.Rule)
+			}
+		})
+	}
+}
+
+func TestPolicy_Compile(t *testing.T) {
+	for _, policy := range []*Policy{
+		{Default: "maybe"},
+		{Rules: []PolicyRule{{Action: "block", Prefix: "rm"}}},
+		{Rules: []PolicyRule{{Action: PolicyDeny}}},
+		{Rules: []PolicyRule{{Action: PolicyDeny, Regex: "("}}},
+	} {
+		if err := policy.Compile(); err == nil {
+			t.Errorf("expected %+v to be invalid", policy)
+		}
+	}
+}
+
+func TestLineEditor_Veto(t *testing.T) {
+	var editor lineEditor
+
+	forward := editor.Feed([]byte("reboot\rid\r"), func(event *InputLineEvent) bool {
+		return event.Line != "reboot"
+	})
+	if string(forward) != "reboot\x03id\r" {
+		t.Errorf("expected the first Enter to be replaced, got %q", forward)
+	}
+}
+
+func TestProxyTTY_PolicyUncertain(t *testing.T) {
+	policy := &Policy{Rules: []PolicyRule{
+		{Action: PolicyDeny, Prefix: "reboot"},
+	}}
+	if err := policy.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, input := range []string{"reb\t\r", "id\x0f\r", "\x1b[A\r"} {
+		t.Run(fmt.Sprintf("%q", input), func(t *testing.T) {
+			var events []*PolicyEvent
+			observer := ObserverFunc(func(event Event) error {
+				if e, ok := event.(*PolicyEvent); ok {
+					events = append(events, e)
+				}
+				return nil
+			})
+
+			slave := &testSlave{}
+			ptty, err := New(nil, &bytes.Buffer{}, slave,
+				WithPermitWrite(),
+				WithCommandPolicy(policy, "alice"),
+				WithObserver(observer),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ptty.Input(ptty.owner, []byte(input))
+
+			if len(events) == 0 || events[len(events)-1].Action != PolicyDeny {
+				t.Fatalf("expected the line to be denied, got %+v", events)
+			}
+			if !strings.HasSuffix(slave.String(), "\x03") {
+				t.Errorf("slave got %q", slave.String())
+			}
+		})
+	}
+}
+
+func TestProxyTTY_PolicyParticipants(t *testing.T) {
+	policy := &Policy{Rules: []PolicyRule{
+		{Action: PolicyDeny, Prefix: "reboot", Users: []string{"bob"}},
+	}}
+	if err := policy.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		bobTypes   string
+		wantAction PolicyAction
+		wantUser   string
+	}{
+		{name: "owner alone", wantAction: PolicyAllow, wantUser: "alice"},
+		{name: "participant denied", bobTypes: "oot", wantAction: PolicyDeny, wantUser: "bob"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []*PolicyEvent
+			observer := ObserverFunc(func(event Event) error {
+				if e, ok := event.(*PolicyEvent); ok {
+					events = append(events, e)
+				}
+				return nil
+			})
+
+			slave := &testSlave{}
+			ptty, err := New(nil, &bytes.Buffer{}, slave,
+				WithPermitWrite(),
+				WithOwner("owner"),
+				WithCommandPolicy(policy, "alice"),
+				WithObserver(observer),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bob := &Master{Name: "bob", PermitWrite: true}
+
+			ptty.Input(ptty.owner, []byte("reb"))
+			if tt.bobTypes != "" {
+				ptty.Input(bob, []byte(tt.bobTypes))
+			} else {
+				ptty.Input(ptty.owner, []byte("oot"))
+			}
+			ptty.Input(ptty.owner, []byte("\r"))
+
+			if len(events) != 1 {
+				t.Fatalf("expected a policy event, got %d", len(events))
+			}
+			if events[0].Action != tt.wantAction || events[0].User != tt.wantUser {
+				t.Errorf("got %s for %s, want %s for %s", events[0].Action, events[0].User, tt.wantAction, tt.wantUser)
+			}
+			if blocked := strings.HasSuffix(slave.String(), "\x03"); blocked != (tt.wantAction == PolicyDeny) {
+				t.Errorf("slave got %q", slave.String())
+			}
+		})
+	}
+}
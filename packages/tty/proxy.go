@@ -3,32 +3,13 @@ package tty
 import (
 	"bufio"
 	"context"
-	"fmt"
 	"io"
 	"sync"
 	"time"
 
 	"github.com/pkg/errors"
-	"github.com/rcrowley/go-metrics"
-	"github.com/x-qdo/qudosh/packages/ttyrec"
 )
 
-type Hook func(r *Recorder) error
-
-type Recorder struct {
-	logger          *ttyrec.Encoder
-	Hook            Hook
-	FileName        string
-	FilePrefix      string
-	KeystrokesMeter metrics.Meter
-	OutputMeter     metrics.Meter
-	Cancel          context.CancelFunc
-}
-
-func (r Recorder) Write(data []byte) (int, error) {
-	return r.logger.Write(data)
-}
-
 type ArgResizeTerminal struct {
 	Columns int
 	Rows    int
@@ -39,44 +20,86 @@ type ArgResizeTerminal struct {
 // terminal resizing, ProxyTTY uses an original protocol.
 type ProxyTTY struct {
 	// PTY Master
-	masterStdin  io.Reader
-	masterStdout io.Writer
+	masterStdin io.Reader
+	owner       *Master
+	masters     []*Master
 	// PTY Slave
 	slave Slave
 
 	windowTitle []byte
-	permitWrite bool
+	iconTitle   []byte
+	titles      titleTracker
 	columns     int
 	rows        int
 
-	bufferSize   int
-	writeMutex   sync.Mutex
-	lastPingTime time.Time
-	logger       *Recorder
+	// input and output are the filter chains of the streams.
+	input       filterChain
+	output      outputPipeline
+	outputMutex sync.Mutex
+	// filterEvents are raised by filters on the output being written.
+	filterEvents  []filterEvent
+	outputLimiter *rateLimiter
+	// recordingFailed is set once a write to the recording failed.
+	recordingFailed bool
+
+	observers []Observer
+	failures  failures
+
+	bufferSize    int
+	writeMutex    sync.Mutex
+	inputMutex    sync.Mutex
+	pingMutex     sync.Mutex
+	lastPingTime  time.Time
+	idleTimeout   time.Duration
+	idleWarning   time.Duration
+	expiresAt     time.Time
+	terminated    error
+	watchers      *watchServer
+	sharedWriters []string
+	supervisors   []string
+	frozen        bool
+	screen        *screenBuffer
+	lastWriter    *Master
+	lineWriters   []string
+	logger        *Recorder
+	commands      *commandTracker
+	lineEditor    *lineEditor
+	auditInput    bool
+	policy        *Policy
+	policyUser    string
 
 	ResizeEvents chan *ArgResizeTerminal
 }
 
 const (
 	MaxBufferSize = 1024 * 1024 * 1
+
+	// exitStatusTimeout is how long to wait for a closed slave to report its exit status.
+	exitStatusTimeout = 1 * time.Second
 )
 
 func New(masterStdin io.Reader, masterStdout io.Writer, slave Slave, options ...Option) (*ProxyTTY, error) {
+	owner := &Master{Name: "owner", Stdout: masterStdout}
 	ptty := &ProxyTTY{
-		masterStdin:  masterStdin,
-		masterStdout: masterStdout,
-		slave:        slave,
-		logger:       nil,
+		masterStdin: masterStdin,
+		owner:       owner,
+		slave:       slave,
+		logger:      nil,
 
-		permitWrite: false,
-		columns:     0,
-		rows:        0,
+		columns: 0,
+		rows:    0,
 
 		bufferSize:   MaxBufferSize,
 		lastPingTime: time.Now(),
 		ResizeEvents: make(chan *ArgResizeTerminal, 1),
 	}
 
+	// Without a master of its own, the session is headless: masters only
+	// attach through the session socket, see ModeAttach.
+	if masterStdout != nil {
+		ptty.masters = append(ptty.masters, owner)
+	}
+
 	for _, option := range options {
 		err := option(ptty)
 		if err != nil {
@@ -95,7 +118,13 @@ func New(masterStdin io.Reader, masterStdout io.Writer, slave Slave, options ...
 // If the connection to one end gets closed, returns ErrSlaveClosed or ErrMasterClosed.
 func (ptty *ProxyTTY) Run(ctx context.Context) error {
 	var err error
-	errs := make(chan error, 3)
+	errs := make(chan error, 6)
+
+	start := &SessionStartEvent{AuditHeader: AuditHeader{Type: "session_start", Time: time.Now()}}
+	if ptty.logger != nil {
+		start.Metadata = ptty.logger.Metadata()
+	}
+	ptty.observe(start)
 
 	slaveBuffer := make([]byte, ptty.bufferSize)
 	go func() {
@@ -120,28 +149,30 @@ func (ptty *ProxyTTY) Run(ctx context.Context) error {
 		}()
 	}()
 	masterBuffer := make([]byte, 4)
-	bufferedStdin := bufio.NewReader(ptty.masterStdin)
-	go func() {
-		errs <- func() error {
-			defer func() {
-				if e := recover(); e != nil {
+	if ptty.masterStdin != nil {
+		bufferedStdin := bufio.NewReader(ptty.masterStdin)
+		go func() {
+			errs <- func() error {
+				defer func() {
+					if e := recover(); e != nil {
+					}
+				}()
+				for {
+					if masterBuffer == nil {
+						return ErrMasterClosed
+					}
+					n, err := bufferedStdin.Read(masterBuffer)
+					if err != nil {
+						return ErrMasterClosed
+					}
+					err = ptty.handleMasterReadEvent(ptty.owner, masterBuffer[:n])
+					if err != nil {
+						return err
+					}
 				}
 			}()
-			for {
-				if masterBuffer == nil {
-					return ErrMasterClosed
-				}
-				n, err := bufferedStdin.Read(masterBuffer)
-				if err != nil {
-					return ErrMasterClosed
-				}
-				err = ptty.handleMasterReadEvent(masterBuffer[:n])
-				if err != nil {
-					return err
-				}
-			}
 		}()
-	}()
+	}
 
 	go func() {
 		errs <- func() error {
@@ -157,8 +188,15 @@ func (ptty *ProxyTTY) Run(ctx context.Context) error {
 						return err
 					}
 
+					size := TerminalSize{Columns: newSize.Columns, Rows: newSize.Rows}
+					ptty.resizeMasters(size)
+					ptty.observe(&ResizeEvent{
+						AuditHeader: AuditHeader{Type: "resize", Time: time.Now()},
+						Size:        size,
+					})
+
 					if ptty.logger != nil {
-						_, err := ptty.logger.Write([]byte(fmt.Sprintf("\u001B[8;%d;%dt", newSize.Rows, newSize.Columns)))
+						err := ptty.logger.Resize(newSize.Columns, newSize.Rows)
 						if err != nil {
 							return err
 						}
@@ -168,62 +206,278 @@ func (ptty *ProxyTTY) Run(ctx context.Context) error {
 		}()
 	}()
 
-	defer func() {
-		slaveBuffer = nil
-		masterBuffer = nil
-		if ptty.logger != nil {
+	if ptty.watchers != nil {
+		go ptty.watchers.Serve()
+	}
 
-			// trigger cancel context
-			ptty.logger.Cancel()
+	if ptty.input.flushes() || ptty.output.flushes() {
+		flushCtx, cancelFlush := context.WithCancel(ctx)
+		defer cancelFlush()
+		go func() {
+			errs <- ptty.flushFilters(flushCtx)
+		}()
+	}
 
-			if ptty.logger.Hook != nil {
-				ptty.logger.Hook(ptty.logger)
-			}
-		}
-	}()
+	if ptty.idleTimeout > 0 {
+		idleCtx, cancelIdle := context.WithCancel(ctx)
+		defer cancelIdle()
+		go func() {
+			errs <- ptty.watchIdle(idleCtx)
+		}()
+	}
+
+	if !ptty.expiresAt.IsZero() {
+		expiryCtx, cancelExpiry := context.WithCancel(ctx)
+		defer cancelExpiry()
+		go func() {
+			errs <- ptty.watchExpiry(expiryCtx)
+		}()
+	}
 
 	select {
 	case <-ctx.Done():
 		err = ctx.Err()
 	case err = <-errs:
 	}
+	slaveBuffer = nil
+	masterBuffer = nil
+
+	// Closing the slave on termination makes the read loop fail as well.
+	if terminated := ptty.terminationError(); terminated != nil {
+		err = terminated
+	}
+
+	ptty.finish(err == ErrSlaveClosed || ptty.terminationError() != nil)
+	return ptty.failures.wrap(err)
+}
+
+// finish releases what the filters held back, disconnects the clients and
+// finalises the recording once the session ended.
+func (ptty *ProxyTTY) finish(slaveExited bool) {
+	ptty.flushInput(true)
+	ptty.flushOutput(true)
+
+	// Clients learn about the end of the session before the
+	// recording is finalised, which may take a while.
+	if ptty.watchers != nil {
+		ptty.watchers.Close()
+	}
+	if slaveExited {
+		ptty.recordExitStatus()
+	}
+	if ptty.logger != nil {
+		ptty.logger.Close()
+	}
+}
+
+// recordExitStatus stores the exit code of a slave that has gone away
+// in the recording metadata and tells the observers about it.
+func (ptty *ProxyTTY) recordExitStatus() {
+	event := &SlaveExitEvent{AuditHeader: AuditHeader{Type: "slave_exit"}}
+	defer func() {
+		event.Time = time.Now()
+		ptty.observe(event)
+	}()
+
+	reporter, ok := ptty.slave.(ExitReporter)
+	if !ok {
+		return
+	}
+
+	// The pty is usually closed just before the process is reaped.
+	select {
+	case <-reporter.Exited():
+	case <-time.After(exitStatusTimeout):
+		return
+	}
+
+	code := reporter.ExitCode()
+	event.ExitStatus = &code
+	if ptty.logger != nil {
+		ptty.logger.UpdateMetadata(func(m *Metadata) {
+			m.ExitStatus = &code
+		})
+	}
+}
+
+// handleInputLine is called with every line submitted on the master
+// and reports whether it may be passed on to the slave.
+func (ptty *ProxyTTY) handleInputLine(event *InputLineEvent) bool {
+	if ptty.logger != nil {
+		event.Frame = ptty.logger.LastFrame()
+	}
+	event.Participants = ptty.lineWriters
+	ptty.lineWriters = nil
+
+	allowed := true
+	if ptty.policy != nil {
+		allowed = ptty.checkPolicy(event)
+	}
+	event.Blocked = !allowed
+
+	if ptty.auditInput {
+		ptty.audit(event)
+	}
+
+	return allowed
+}
+
+// audit writes event to the audit log of the recording, if any,
+// and passes it to the observers.
+func (ptty *ProxyTTY) audit(event Event) {
+	if ptty.logger != nil {
+		ptty.logger.Audit(event)
+	}
+	ptty.observe(event)
+}
+
+// recorderEvent is called by the Recorder for its segment and upload events.
+func (ptty *ProxyTTY) recorderEvent(event Event) {
+	if upload, ok := event.(*UploadFinishedEvent); ok && upload.Err != nil {
+		ptty.failures.add(upload.Err)
+	}
+	ptty.observe(event)
+}
 
-	return err
+// recordingError handles an error writing the output to the recording. A stalled
+// recording ends the session, other errors are reported once in the result of Run.
+// It must be called with outputMutex held.
+func (ptty *ProxyTTY) recordingError(err error) {
+	if err == ErrRecordingStalled {
+		if ptty.terminationError() == nil {
+			reason := "the recording cannot keep up with the output"
+			ptty.masterWrite([]byte("\r\nqudosh: session terminated, " + reason + "\r\n"))
+			ptty.terminate(reason, ErrRecordingStalled)
+		}
+		return
+	}
+
+	if !ptty.recordingFailed {
+		ptty.recordingFailed = true
+		ptty.failures.add(errors.Wrap(err, "recording failed"))
+	}
 }
 
 func (ptty *ProxyTTY) handleSlaveReadEvent(data []byte) error {
+	ptty.touch()
+	if ptty.outputLimiter != nil {
+		time.Sleep(ptty.outputLimiter.reserve(len(data)))
+	}
 	if ptty.logger != nil {
-		ptty.logger.Write(data)
 		ptty.logger.OutputMeter.Mark(int64(1))
-	}
-	err := ptty.masterWrite(data)
-	if err != nil {
-		return errors.Wrapf(err, "failed to send message to master")
+		ptty.logger.OutputBytesMeter.Mark(int64(len(data)))
 	}
 
-	return nil
+	ptty.outputMutex.Lock()
+	defer ptty.outputMutex.Unlock()
+
+	live, record := ptty.output.Process(data)
+	return ptty.writeOutput(live, record)
 }
 
-func (ptty *ProxyTTY) masterWrite(data []byte) error {
+// writeOutput writes the recorded copy of the output to the recording and
+// the live one to the masters. It must be called with outputMutex held.
+func (ptty *ProxyTTY) writeOutput(live, record []byte) error {
+	if len(live) == 0 && len(record) == 0 {
+		return nil
+	}
+
+	var pos FramePosition
+	if ptty.logger != nil && len(record) > 0 {
+		if _, err := ptty.logger.Write(record); err != nil {
+			ptty.recordingError(err)
+		}
+		pos = ptty.logger.LastFrame()
+
+		if ptty.commands != nil {
+			ptty.commands.Feed(record, pos, func(event *CommandEvent) {
+				ptty.audit(event)
+			})
+		}
+	}
+
 	ptty.writeMutex.Lock()
 	defer ptty.writeMutex.Unlock()
 
-	_, err := ptty.masterStdout.Write(data)
+	ptty.trackTitle(record, pos)
+	for _, event := range ptty.filterEvents {
+		event.at(pos)
+		ptty.audit(event)
+	}
+	ptty.filterEvents = nil
+	ptty.observe(&OutputEvent{
+		AuditHeader: AuditHeader{Type: "output", Time: time.Now()},
+		Live:        live,
+		Recorded:    record,
+		Frame:       pos,
+	})
+
+	if len(live) == 0 {
+		return nil
+	}
+	if ptty.screen != nil {
+		ptty.screen.Write(live)
+	}
+
+	err := ptty.writeMasters(live)
 	if err != nil {
-		return errors.Wrapf(err, "failed to write to master")
+		return errors.Wrapf(err, "failed to send message to master")
 	}
 
 	return nil
 }
 
-func (ptty *ProxyTTY) handleMasterReadEvent(buf []byte) error {
-	if !ptty.permitWrite {
+func (ptty *ProxyTTY) handleMasterReadEvent(m *Master, buf []byte) error {
+	if !m.PermitWrite {
 		return nil
 	}
 
+	// Input of several masters is merged, one chunk at a time.
+	ptty.inputMutex.Lock()
+	defer ptty.inputMutex.Unlock()
+
+	// Discarded input does not keep the session alive.
+	if ptty.frozen {
+		return nil
+	}
+	ptty.touch()
+
+	if m != ptty.lastWriter {
+		if ptty.lastWriter != nil || m != ptty.owner {
+			ptty.recordInputTurn(m)
+		}
+		ptty.lastWriter = m
+	}
+	if !containsString(ptty.lineWriters, m.Name) {
+		ptty.lineWriters = append(ptty.lineWriters, m.Name)
+	}
+
 	if ptty.logger != nil {
 		ptty.logger.KeystrokesMeter.Mark(int64(1))
+		ptty.logger.InputBytesMeter.Mark(int64(len(buf)))
 	}
+	return ptty.writeInput(ptty.input.run(buf))
+}
+
+// writeInput passes filtered input through the line editor on to the slave.
+// It must be called with inputMutex held.
+func (ptty *ProxyTTY) writeInput(buf []byte) error {
+	if len(buf) == 0 {
+		return nil
+	}
+	if ptty.lineEditor != nil {
+		buf = ptty.lineEditor.Feed(buf, ptty.handleInputLine)
+	}
+
+	event := &InputEvent{
+		AuditHeader: AuditHeader{Type: "input", Time: time.Now()},
+		Data:        buf,
+	}
+	if ptty.lastWriter != nil {
+		event.Participant = ptty.lastWriter.Name
+	}
+	ptty.observe(event)
+
 	_, err := ptty.slave.Write(buf)
 	if err != nil {
 		return errors.Wrapf(err, "failed to write received data to slave")
@@ -231,3 +485,23 @@ func (ptty *ProxyTTY) handleMasterReadEvent(buf []byte) error {
 
 	return nil
 }
+
+func (ptty *ProxyTTY) recordInputTurn(m *Master) {
+	event := &InputTurnEvent{
+		AuditHeader: AuditHeader{Type: "input_turn", Time: time.Now()},
+		Participant: m.Name,
+	}
+	if ptty.logger != nil {
+		event.Frame = ptty.logger.LastFrame()
+	}
+	ptty.audit(event)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package tty
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// DefaultQueueSize is how many bytes of output WriteAsync queues when no size is given.
+const DefaultQueueSize = 4 * 1024 * 1024
+
+// QueuePolicy decides what happens to output recorded while the write queue is full.
+type QueuePolicy string
+
+const (
+	// QueueBlock waits for the queue to make room, stalling the session
+	// like synchronous recording does.
+	QueueBlock QueuePolicy = "block"
+	// QueueDrop leaves the output out of the recording, with a marker of
+	// the gap once the queue makes room again.
+	QueueDrop QueuePolicy = "drop"
+	// QueueTerminate ends the session with ErrRecordingStalled.
+	QueueTerminate QueuePolicy = "terminate"
+)
+
+// errFrameDropped is returned by writeQueue.reserve for output to be dropped.
+var errFrameDropped = errors.New("frame dropped")
+
+// errSegmentNotOpen is returned by queued writes to a segment whose files
+// could not be created.
+var errSegmentNotOpen = errors.New("segment not open")
+
+// ParseQueuePolicy parses the name of a QueuePolicy.
+func ParseQueuePolicy(s string) (QueuePolicy, error) {
+	switch policy := QueuePolicy(s); policy {
+	case QueueBlock, QueueDrop, QueueTerminate:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown queue policy %q", s)
+}
+
+// WriteAsync moves the writes of the recording to a goroutine, so that a slow
+// or hanging disk does not hold up the session. Up to size bytes of output are
+// queued, policy decides what happens beyond that. Frame positions and
+// rotation are still decided as the output is written; the files of new
+// segments are created and those of rotated ones completed on the writer
+// goroutine, the final one waits for the queue.
+func WriteAsync(size int, policy QueuePolicy) RecorderOption {
+	return func(r *Recorder) {
+		if size <= 0 {
+			size = DefaultQueueSize
+		}
+		r.queue = newWriteQueue(size, policy)
+	}
+}
+
+// writeQueue runs the writes of a Recorder in order on its own goroutine.
+type writeQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	ops    []queuedWrite
+	queued int
+	size   int
+	policy QueuePolicy
+	busy   bool
+	closed bool
+	err    error
+	done   chan struct{}
+}
+
+type queuedWrite struct {
+	size  int
+	write func() error
+}
+
+func newWriteQueue(size int, policy QueuePolicy) *writeQueue {
+	q := &writeQueue{
+		size:   size,
+		policy: policy,
+		done:   make(chan struct{}),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// run writes the queued data until the queue is closed and empty.
+func (q *writeQueue) run() {
+	defer close(q.done)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		for len(q.ops) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.ops) == 0 {
+			return
+		}
+
+		op := q.ops[0]
+		q.ops[0] = queuedWrite{}
+		q.ops = q.ops[1:]
+		q.busy = true
+		q.mu.Unlock()
+
+		err := op.write()
+
+		q.mu.Lock()
+		q.busy = false
+		q.queued -= op.size
+		if err != nil && q.err == nil {
+			q.err = err
+		}
+		q.cond.Broadcast()
+	}
+}
+
+// reserve makes sure that size bytes of output fit in the queue, according to
+// the policy. Output larger than the queue is accepted when the queue is empty.
+func (q *writeQueue) reserve(size int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.queued > 0 && q.queued+size > q.size {
+		switch q.policy {
+		case QueueDrop:
+			return errFrameDropped
+		case QueueTerminate:
+			return ErrRecordingStalled
+		}
+		q.cond.Wait()
+	}
+	return nil
+}
+
+// enqueue adds write, which writes size bytes of output, to the queue.
+func (q *writeQueue) enqueue(size int, write func() error) {
+	q.mu.Lock()
+	q.ops = append(q.ops, queuedWrite{size: size, write: write})
+	q.queued += size
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// drain waits until everything queued has been written.
+func (q *writeQueue) drain() {
+	q.mu.Lock()
+	for len(q.ops) > 0 || q.busy {
+		q.cond.Wait()
+	}
+	q.mu.Unlock()
+}
+
+// error returns the first write that failed.
+func (q *writeQueue) error() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.err
+}
+
+// close stops the goroutine once the queue is empty.
+func (q *writeQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+	<-q.done
+}
@@ -0,0 +1,167 @@
+package tty
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/x-qdo/qudosh/packages/ttyrec"
+)
+
+// stall fills the queue of r until the returned function is called.
+func stall(r *Recorder) func() {
+	release := make(chan struct{})
+	r.mu.Lock()
+	r.queue.enqueue(r.queue.size, func() error {
+		<-release
+		return nil
+	})
+	r.mu.Unlock()
+	return func() { close(release) }
+}
+
+func TestRecorder_WriteAsyncDrop(t *testing.T) {
+	dir := t.TempDir()
+	r, err := newRecorder(context.Background(), dir, "session.ttyrec", nil, WriteAsync(16, QueueDrop))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := stall(r)
+	if _, err = r.Write([]byte("lost")); err != nil {
+		t.Fatal(err)
+	}
+	release()
+	r.queue.drain()
+	if _, err = r.Write([]byte("kept")); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "session.ttyrec"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	dec := ttyrec.NewDecoder(f)
+	for _, want := range []string{"4 bytes of output dropped", "kept"} {
+		frame, err := dec.DecodeFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(frame.Data), want) {
+			t.Errorf("expected a frame with %q, got %q", want, frame.Data)
+		}
+	}
+
+	csv, err := os.ReadFile(filepath.Join(dir, "session.ttyrec.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(csv)), "\n")
+	if last := lines[len(lines)-1]; !strings.HasSuffix(last, ";4") {
+		t.Errorf("expected 4 dropped bytes in %q", last)
+	}
+}
+
+func TestRecorder_WriteAsyncTerminate(t *testing.T) {
+	r, err := newRecorder(context.Background(), t.TempDir(), "session.ttyrec", nil, WriteAsync(16, QueueTerminate))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := stall(r)
+	if _, err = r.Write([]byte("output")); err != ErrRecordingStalled {
+		t.Errorf("expected %v, got %v", ErrRecordingStalled, err)
+	}
+	release()
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecorder_WriteAsyncRotate(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		mu       sync.Mutex
+		uploaded []string
+	)
+	upload := func(s *Segment) error {
+		mu.Lock()
+		defer mu.Unlock()
+		uploaded = append(uploaded, s.FileName)
+		return nil
+	}
+
+	r, err := newRecorder(context.Background(), dir, "session.ttyrec", upload,
+		WriteAsync(1024, QueueBlock),
+		RotateBySize(8),
+		WithMetadata(&Metadata{SessionID: "s"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The disk hangs, without filling the queue.
+	release := make(chan struct{})
+	r.queue.enqueue(0, func() error {
+		<-release
+		return nil
+	})
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := r.Write([]byte("0123456789"))
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("rotation waited for the queue")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "session.001.ttyrec")); !os.IsNotExist(err) {
+		t.Errorf("segment completed before its output was written: %v", err)
+	}
+	for _, name := range []string{"session.002.ttyrec" + incompleteSuffix, "session.002.ttyrec.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s created outside of the writer goroutine: %v", name, err)
+		}
+	}
+
+	close(release)
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"session.001.ttyrec", "session.002.ttyrec"}
+	sort.Strings(uploaded)
+	if !reflect.DeepEqual(uploaded, want) {
+		t.Errorf("uploaded %q, want %q", uploaded, want)
+	}
+	for _, name := range want {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestParseQueuePolicy(t *testing.T) {
+	if policy, err := ParseQueuePolicy("drop"); err != nil || policy != QueueDrop {
+		t.Errorf("got %q, %v", policy, err)
+	}
+	if _, err := ParseQueuePolicy("ignore"); err == nil {
+		t.Error("unknown policy accepted")
+	}
+}
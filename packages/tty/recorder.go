@@ -0,0 +1,630 @@
+package tty
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rcrowley/go-metrics"
+	"github.com/x-qdo/qudosh/packages/ttyrec"
+)
+
+// frameHeaderSize is the size of a ttyrec frame header.
+const frameHeaderSize = 12
+
+// segmentCheckInterval is how often SyncByDuration and RotateByDuration are
+// checked, whatever the metrics interval.
+const segmentCheckInterval = time.Second
+
+// Uploader is called for every completed segment of a recording.
+type Uploader func(s *Segment) error
+
+// Segment describes a single ttyrec/CSV pair written by the Recorder.
+type Segment struct {
+	// Index is the sequence number of the segment, starting at 1.
+	Index      int
+	FilePrefix string
+	FileName   string
+	StartedAt  time.Time
+	EndedAt    time.Time
+	// Final is set for the last segment of a session.
+	Final bool
+	// Artifacts lists the files written for the segment, relative to FilePrefix.
+	Artifacts []string
+}
+
+// RecorderOption is an option for the Recorder.
+type RecorderOption func(*Recorder)
+
+// RotateBySize starts a new segment once the current ttyrec file
+// grows beyond size bytes.
+func RotateBySize(size int64) RecorderOption {
+	return func(r *Recorder) {
+		r.rotateSize = size
+	}
+}
+
+// RotateByDuration starts a new segment once the current one
+// has been recording for longer than d.
+func RotateByDuration(d time.Duration) RecorderOption {
+	return func(r *Recorder) {
+		r.rotateDuration = d
+	}
+}
+
+// Recorder writes the slave output to ttyrec files together with
+// the activity metrics, optionally rotating them into segments.
+type Recorder struct {
+	Upload     Uploader
+	FileName   string
+	FilePrefix string
+	// KeystrokesMeter and OutputMeter count the reads of the input and
+	// the output, InputBytesMeter and OutputBytesMeter their bytes.
+	KeystrokesMeter  metrics.Meter
+	InputBytesMeter  metrics.Meter
+	OutputMeter      metrics.Meter
+	OutputBytesMeter metrics.Meter
+
+	metricsInterval time.Duration
+	metricsFormat   MetricsFormat
+	sampled         metricsCounters
+
+	rotateSize     int64
+	rotateDuration time.Duration
+
+	coalesceWindow time.Duration
+	coalesceSize   int
+	pending        []byte
+	pendingAt      time.Time
+	pendingTimer   *time.Timer
+
+	syncDuration  time.Duration
+	syncSize      int64
+	syncOnCommand bool
+
+	queue   *writeQueue
+	gap     int
+	dropped metrics.Counter
+
+	mu         sync.Mutex
+	segment    *segment
+	index      int
+	lastResize []byte
+	metadata   *Metadata
+	lastFrame  FramePosition
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	uploads sync.WaitGroup
+
+	// events receives the segment and upload events, see ProxyTTY.observe.
+	events func(event Event)
+}
+
+type segment struct {
+	Segment
+
+	file        *os.File
+	metricsFile *os.File
+	encoder     *ttyrec.Encoder
+	written     int64
+	frames      int
+	unsynced    int64
+	syncedAt    time.Time
+
+	audit *os.File
+}
+
+func newRecorder(parent context.Context, filePrefix, fileName string, upload Uploader, options ...RecorderOption) (*Recorder, error) {
+	r := &Recorder{
+		Upload:           upload,
+		FileName:         fileName,
+		FilePrefix:       filePrefix,
+		KeystrokesMeter:  metrics.NewMeter(),
+		InputBytesMeter:  metrics.NewMeter(),
+		OutputMeter:      metrics.NewMeter(),
+		OutputBytesMeter: metrics.NewMeter(),
+		dropped:          metrics.NewCounter(),
+		metricsInterval:  MetricsInterval,
+		metricsFormat:    MetricsCSV,
+		done:             make(chan struct{}),
+	}
+
+	for _, option := range options {
+		option(r)
+	}
+
+	if r.metadata != nil && r.metadata.StartedAt.IsZero() {
+		r.metadata.StartedAt = time.Now()
+	}
+
+	if err := r.openSegment(); err != nil {
+		r.stopMeters()
+		return nil, err
+	}
+
+	if r.queue != nil {
+		go r.queue.run()
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	r.cancel = cancel
+	go r.tick(ctx)
+
+	return r, nil
+}
+
+// rotating reports whether segments are numbered.
+func (r *Recorder) rotating() bool {
+	return r.rotateSize > 0 || r.rotateDuration > 0
+}
+
+// segmentName returns the file name of the segment with the given index.
+// Without rotation the configured file name is used as is.
+func (r *Recorder) segmentName(index int) string {
+	if !r.rotating() {
+		return r.FileName
+	}
+	ext := filepath.Ext(r.FileName)
+	return fmt.Sprintf("%s.%03d%s", strings.TrimSuffix(r.FileName, ext), index, ext)
+}
+
+// openSegment starts a new ttyrec/CSV pair. It must be called with mu held
+// or before the recorder is shared. With WriteAsync, the files of rotated
+// segments are created on the writer goroutine; the first segment is always
+// created right away, so that newRecorder fails when it cannot be.
+func (r *Recorder) openSegment() error {
+	r.index++
+	s := &segment{
+		Segment: Segment{
+			Index:      r.index,
+			FilePrefix: r.FilePrefix,
+			FileName:   r.segmentName(r.index),
+			StartedAt:  time.Now(),
+		},
+		syncedAt: time.Now(),
+	}
+
+	metadata, err := r.encodeMetadata(s)
+	if err != nil {
+		log.Print(err)
+	}
+	suffix, header := r.metricsSuffix(), r.metricsHeader()
+	open := func() error {
+		return s.open(suffix, header, metadata)
+	}
+
+	if r.index == 1 {
+		err = open()
+	} else {
+		err = r.perform(open)
+	}
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	r.segment = s
+
+	// write the first line
+	r.writeMetrics()
+
+	// A new segment has to be playable on its own, so restore the terminal size.
+	if r.lastResize != nil {
+		return r.writeFrame(r.lastResize, time.Now())
+	}
+
+	return nil
+}
+
+// open creates the files of the segment under their incomplete names, writes
+// the header naming the metrics columns and the metadata sidecar, if any.
+func (s *segment) open(metricsSuffix, metricsHeader string, metadata []byte) error {
+	f, err := createIncomplete(s.FilePrefix, s.FileName)
+	if err != nil {
+		return err
+	}
+
+	metricsFile, err := createIncomplete(s.FilePrefix, s.FileName+metricsSuffix)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	fmt.Fprint(metricsFile, metricsHeader)
+
+	s.file = f
+	s.metricsFile = metricsFile
+	s.encoder = ttyrec.NewEncoder(f)
+
+	if metadata != nil {
+		if err := s.writeMetadata(metadata); err != nil {
+			log.Print(err)
+		}
+	}
+	return nil
+}
+
+// closeSegment detaches the current segment from the recorder and returns it
+// along with its metadata sidecar, see completeRotated and completeFinal.
+// It must be called with mu held.
+func (r *Recorder) closeSegment(final bool) (*segment, []byte) {
+	if err := r.flushPending(); err != nil {
+		log.Print(err)
+	}
+	if err := r.markGap(time.Now()); err != nil {
+		log.Print(err)
+	}
+
+	// write the last line
+	r.writeMetrics()
+
+	s := r.segment
+	r.segment = nil
+
+	s.EndedAt = time.Now()
+	s.Final = final
+	if final && r.metadata != nil {
+		r.metadata.EndedAt = &s.EndedAt
+	}
+	metadata, err := r.encodeMetadata(s)
+	if err != nil {
+		log.Print(err)
+	}
+	return s, metadata
+}
+
+// completeRotated completes a rotated segment and uploads it in the background.
+// With WriteAsync, the segment is completed on the writer goroutine once its
+// output is written, so that a slow disk does not hold up the session.
+func (r *Recorder) completeRotated(s *segment, metadata []byte) {
+	r.uploads.Add(1)
+	if r.queue == nil {
+		go func() {
+			defer r.uploads.Done()
+			completed := s.finish(metadata)
+			r.finalized(completed)
+			r.uploadRotated(completed)
+		}()
+		return
+	}
+	r.queue.enqueue(0, func() error {
+		completed := s.finish(metadata)
+		r.finalized(completed)
+		go func() {
+			defer r.uploads.Done()
+			r.uploadRotated(completed)
+		}()
+		return nil
+	})
+}
+
+// completeFinal waits for the output of the final segment to be written,
+// completes the segment and uploads it. It must be called without mu held,
+// as the events of the segment are delivered to observers that may use
+// the Recorder.
+func (r *Recorder) completeFinal(s *segment, metadata []byte) error {
+	if r.queue != nil {
+		r.queue.drain()
+	}
+	completed := s.finish(metadata)
+	r.finalized(completed)
+
+	if r.Upload == nil {
+		return nil
+	}
+	return r.upload(completed)
+}
+
+// finish completes the files of the segment and writes its metadata, if any.
+// It returns the completed segment.
+func (s *segment) finish(metadata []byte) Segment {
+	s.Artifacts = s.complete()
+	if metadata != nil {
+		if err := s.writeMetadata(metadata); err != nil {
+			log.Print(err)
+		} else {
+			s.Artifacts = append(s.Artifacts, s.FileName+".json")
+		}
+	}
+	return s.Segment
+}
+
+func (r *Recorder) finalized(completed Segment) {
+	r.event(&SegmentFinalizedEvent{
+		AuditHeader: AuditHeader{Type: "segment_finalized", Time: time.Now()},
+		Segment:     completed,
+	})
+}
+
+// uploadRotated uploads a rotated segment, the errors are only logged.
+func (r *Recorder) uploadRotated(completed Segment) {
+	if r.Upload == nil {
+		return
+	}
+	if err := r.upload(completed); err != nil {
+		log.Print(err)
+	}
+}
+
+// upload runs the Uploader for a completed segment.
+func (r *Recorder) upload(completed Segment) error {
+	err := r.Upload(&completed)
+	if err != nil {
+		err = errors.Wrapf(err, "upload failed for segment %s", completed.FileName)
+	}
+
+	r.event(&UploadFinishedEvent{
+		AuditHeader: AuditHeader{Type: "upload_finished", Time: time.Now()},
+		Segment:     completed,
+		Err:         err,
+	})
+	return err
+}
+
+func (r *Recorder) event(event Event) {
+	if r.events != nil {
+		r.events(event)
+	}
+}
+
+// rotate closes the current segment and opens the next one. It must be called with mu held.
+func (r *Recorder) rotate() error {
+	r.completeRotated(r.closeSegment(false))
+	return r.openSegment()
+}
+
+func (r *Recorder) shouldRotate() bool {
+	if r.rotateSize > 0 && r.segment.written >= r.rotateSize {
+		return true
+	}
+	if r.rotateDuration > 0 && time.Since(r.segment.StartedAt) >= r.rotateDuration {
+		return true
+	}
+	return false
+}
+
+func (r *Recorder) writeMetrics() {
+	s := r.segment
+	line := r.metricsLine()
+	r.perform(func() error {
+		if s.metricsFile == nil {
+			return errSegmentNotOpen
+		}
+		_, err := s.metricsFile.WriteString(line)
+		return err
+	})
+}
+
+// tick samples the metrics every metricsInterval and, on its own schedule,
+// syncs and rotates the segments that have been open for long enough.
+func (r *Recorder) tick(ctx context.Context) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.metricsInterval)
+	defer ticker.Stop()
+
+	var checks <-chan time.Time
+	if r.syncDuration > 0 || r.rotateDuration > 0 {
+		checker := time.NewTicker(r.checkInterval())
+		defer checker.Stop()
+		checks = checker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.mu.Lock()
+			if r.segment != nil {
+				r.writeMetrics()
+			}
+			r.mu.Unlock()
+		case <-checks:
+			r.mu.Lock()
+			if r.segment != nil {
+				if r.syncDuration > 0 && time.Since(r.segment.syncedAt) >= r.syncDuration {
+					r.sync()
+				}
+				if r.rotating() && r.shouldRotate() {
+					if err := r.rotate(); err != nil {
+						log.Print(err)
+					}
+				}
+			}
+			r.mu.Unlock()
+		}
+	}
+}
+
+// checkInterval is how often the segment is checked for SyncByDuration and
+// RotateByDuration: segmentCheckInterval, or more often for shorter durations.
+func (r *Recorder) checkInterval() time.Duration {
+	interval := segmentCheckInterval
+	for _, d := range []time.Duration{r.syncDuration, r.rotateDuration} {
+		if d > 0 && d < interval {
+			interval = d
+		}
+	}
+	return interval
+}
+
+// write records output as the next frame. With WriteAsync, the output is
+// dropped when the queue is full and the policy says so.
+func (r *Recorder) write(data []byte, at time.Time) error {
+	if r.queue == nil || len(data) == 0 {
+		return r.writeFrame(data, at)
+	}
+
+	switch err := r.queue.reserve(len(data)); err {
+	case nil:
+	case errFrameDropped:
+		r.gap += len(data)
+		r.dropped.Inc(int64(len(data)))
+		return nil
+	default:
+		return err
+	}
+
+	if err := r.markGap(at); err != nil {
+		return err
+	}
+	if err := r.writeFrame(data, at); err != nil {
+		return err
+	}
+	return r.queue.error()
+}
+
+// writeFrame writes data as the next frame of the current segment,
+// on the writer goroutine with WriteAsync.
+func (r *Recorder) writeFrame(data []byte, at time.Time) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	s := r.segment
+	if r.queue != nil {
+		data = append([]byte(nil), data...)
+		r.queue.enqueue(len(data), func() error {
+			if s.encoder == nil {
+				return errSegmentNotOpen
+			}
+			_, err := s.encoder.WriteAt(data, at)
+			return err
+		})
+		r.countFrame(len(data))
+		return nil
+	}
+
+	n, err := s.encoder.WriteAt(data, at)
+	if n > 0 {
+		r.countFrame(n)
+	}
+	return err
+}
+
+// countFrame accounts for a frame of n bytes written to the current segment.
+func (r *Recorder) countFrame(n int) {
+	r.segment.written += int64(n + frameHeaderSize)
+	r.segment.unsynced += int64(n + frameHeaderSize)
+	r.lastFrame = FramePosition{Segment: r.segment.Index, Frame: r.segment.frames}
+	r.segment.frames++
+}
+
+// markGap records a marker in place of the output dropped since the
+// last frame, if any.
+func (r *Recorder) markGap(at time.Time) error {
+	if r.gap == 0 {
+		return nil
+	}
+
+	marker := fmt.Sprintf("\x1b[0m\r\n[qudosh: %d bytes of output dropped, the recording fell behind]\r\n", r.gap)
+	r.gap = 0
+	return r.writeFrame([]byte(marker), at)
+}
+
+// perform runs write, on the writer goroutine with WriteAsync. Errors of
+// queued writes are returned by later calls to Write.
+func (r *Recorder) perform(write func() error) error {
+	if r.queue == nil {
+		return write()
+	}
+	r.queue.enqueue(0, write)
+	return nil
+}
+
+// Write records data as a single ttyrec frame, or as part of one with CoalesceFrames.
+func (r *Recorder) Write(data []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.segment == nil {
+		return 0, ErrRecorderClosed
+	}
+
+	var err error
+	if r.coalesceWindow > 0 {
+		err = r.coalesce(data)
+	} else {
+		err = r.write(data, time.Now())
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if r.syncSize > 0 && r.segment.unsynced >= r.syncSize {
+		r.sync()
+	}
+
+	if r.rotating() && r.shouldRotate() {
+		if err := r.rotate(); err != nil {
+			return len(data), err
+		}
+	}
+
+	return len(data), nil
+}
+
+// Resize records a terminal resize, which is replayed at the start of every new segment.
+// Resizes are never merged with the output nor dropped.
+func (r *Recorder) Resize(columns, rows int) error {
+	seq := []byte(fmt.Sprintf("\u001B[8;%d;%dt", rows, columns))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastResize = seq
+	if r.segment == nil {
+		return ErrRecorderClosed
+	}
+
+	if err := r.flushPending(); err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := r.markGap(now); err != nil {
+		return err
+	}
+	return r.writeFrame(seq, now)
+}
+
+// Close finishes the last segment, uploads it and waits for pending uploads
+// of rotated segments. It returns the error of the last upload.
+func (r *Recorder) Close() error {
+	r.cancel()
+	<-r.done
+
+	r.mu.Lock()
+	var (
+		final    *segment
+		metadata []byte
+	)
+	if r.segment != nil {
+		final, metadata = r.closeSegment(true)
+	}
+	r.mu.Unlock()
+
+	var err error
+	if final != nil {
+		err = r.completeFinal(final, metadata)
+	}
+
+	if r.queue != nil {
+		r.queue.close()
+	}
+	r.uploads.Wait()
+	r.stopMeters()
+
+	return err
+}
+
+func (r *Recorder) stopMeters() {
+	for _, m := range []metrics.Meter{r.KeystrokesMeter, r.InputBytesMeter, r.OutputMeter, r.OutputBytesMeter} {
+		m.Stop()
+	}
+}
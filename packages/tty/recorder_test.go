@@ -0,0 +1,146 @@
+package tty
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/x-qdo/qudosh/packages/ttyrec"
+)
+
+func TestRecorder_RotateBySize(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		mu       sync.Mutex
+		segments []Segment
+	)
+
+	upload := func(s *Segment) error {
+		mu.Lock()
+		defer mu.Unlock()
+		segments = append(segments, *s)
+		return nil
+	}
+
+	r, err := newRecorder(context.Background(), dir, "session.ttyrec", upload, RotateBySize(32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err = r.Write([]byte("0123456789abcdefghij")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(segments) != 4 {
+		t.Fatalf("expected 4 segments, got %d", len(segments))
+	}
+
+	for _, s := range segments {
+		if s.Final != (s.Index == 4) {
+			t.Errorf("segment %d: unexpected final flag %v", s.Index, s.Final)
+		}
+		for _, name := range []string{s.FileName, s.FileName + ".csv"} {
+			if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+				t.Errorf("segment %d: %v", s.Index, err)
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "session.003.ttyrec")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRecorder_CoalesceFrames(t *testing.T) {
+	dir := t.TempDir()
+	r, err := newRecorder(context.Background(), dir, "session.ttyrec", nil, CoalesceFrames(50*time.Millisecond, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Merged up to the maximum size, then flushed by the timer.
+	for _, part := range []string{"ab", "cd", "efgh", "ij"} {
+		if _, err = r.Write([]byte(part)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if frame := r.LastFrame(); frame.Frame != 1 {
+		t.Errorf("pending output at frame %d, expected 1", frame.Frame)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// Flushed on close.
+	if _, err = r.Write([]byte("kl")); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "session.ttyrec"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var (
+		dec   = ttyrec.NewDecoder(f)
+		first *ttyrec.Frame
+	)
+	for i, want := range []string{"abcdefgh", "ij", "kl"} {
+		frame, err := dec.DecodeFrame()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if string(frame.Data) != want {
+			t.Errorf("frame %d: expected %q, got %q", i, want, frame.Data)
+		}
+		if first == nil {
+			first = frame
+		} else if i == 2 && frame.Time.Sub(first.Time) < 100*time.Millisecond {
+			t.Errorf("frame %d: lost the time of its first byte", i)
+		}
+	}
+	if _, err := dec.DecodeFrame(); err != io.EOF {
+		t.Errorf("expected 3 frames, got more (%v)", err)
+	}
+}
+
+func TestRecorder_RotateByDuration(t *testing.T) {
+	dir := t.TempDir()
+
+	// Rotation does not wait for the metrics to be sampled.
+	r, err := newRecorder(context.Background(), dir, "session.ttyrec", nil,
+		RotateByDuration(20*time.Millisecond),
+		WithMetricsInterval(time.Hour),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "session.003.ttyrec")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWithMetricsInterval(t *testing.T) {
+	for _, d := range []time.Duration{0, -time.Second} {
+		r := &Recorder{metricsInterval: MetricsInterval}
+		WithMetricsInterval(d)(r)
+		if r.metricsInterval != MetricsInterval {
+			t.Errorf("interval %s accepted", d)
+		}
+	}
+}
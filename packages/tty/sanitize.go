@@ -0,0 +1,245 @@
+package tty
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SequenceClass groups escape sequences the sanitiser can strip.
+type SequenceClass string
+
+const (
+	// ClassClipboard is OSC 52, which reads or writes the clipboard.
+	ClassClipboard SequenceClass = "clipboard"
+	// ClassReports are queries answered by the terminal as if typed:
+	// DECRQSS, XTGETTCAP and the title reports (CSI 20 t, CSI 21 t).
+	ClassReports SequenceClass = "reports"
+	// ClassTitle sets the window or icon title (OSC 0, 1 and 2).
+	ClassTitle SequenceClass = "title"
+	// ClassHyperlinks is OSC 8, which turns text into links.
+	ClassHyperlinks SequenceClass = "hyperlinks"
+	// ClassFonts is OSC 50, which changes the font.
+	ClassFonts SequenceClass = "fonts"
+)
+
+// SanitizeAction is what the sanitiser does with a class of sequences.
+type SanitizeAction string
+
+const (
+	SanitizeAllow SanitizeAction = "allow"
+	SanitizeStrip SanitizeAction = "strip"
+)
+
+// SanitizePolicy maps sequence classes to actions. Classes missing from the
+// policy are allowed.
+type SanitizePolicy map[SequenceClass]SanitizeAction
+
+// DefaultSanitizePolicy strips the sequences that can leak data or inject
+// input, and allows the cosmetic ones.
+func DefaultSanitizePolicy() SanitizePolicy {
+	return SanitizePolicy{
+		ClassClipboard:  SanitizeStrip,
+		ClassReports:    SanitizeStrip,
+		ClassTitle:      SanitizeAllow,
+		ClassHyperlinks: SanitizeAllow,
+		ClassFonts:      SanitizeStrip,
+	}
+}
+
+// ParseSanitizePolicy applies comma separated class=action pairs, such as
+// "title=strip,hyperlinks=strip", to the default policy.
+func ParseSanitizePolicy(s string) (SanitizePolicy, error) {
+	policy := DefaultSanitizePolicy()
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		class, action, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid sanitize rule %q, expected class=action", pair)
+		}
+		if _, known := policy[SequenceClass(class)]; !known {
+			return nil, fmt.Errorf("unknown sequence class %q", class)
+		}
+		switch a := SanitizeAction(action); a {
+		case SanitizeAllow, SanitizeStrip:
+			policy[SequenceClass(class)] = a
+		default:
+			return nil, fmt.Errorf("unknown sanitize action %q", action)
+		}
+	}
+	return policy, nil
+}
+
+// SanitizeEvent records a sequence stripped from the output shown on the
+// masters. The recording keeps it at Frame.
+type SanitizeEvent struct {
+	AuditHeader
+
+	Class SequenceClass `json:"class"`
+	// Sequence is the beginning of the stripped sequence, quoted.
+	Sequence string        `json:"sequence"`
+	Length   int           `json:"length"`
+	Frame    FramePosition `json:"frame"`
+}
+
+func (e *SanitizeEvent) at(pos FramePosition) {
+	e.Time = time.Now()
+	e.Frame = pos
+}
+
+// maxSanitizeQuote bounds the part of a stripped sequence quoted in its event.
+const maxSanitizeQuote = 32
+
+// WithSanitizer strips the escape sequences denied by policy from the output
+// shown on the masters and records every stripped sequence.
+func WithSanitizer(policy SanitizePolicy) Option {
+	return func(ptty *ProxyTTY) error {
+		s := &sanitizer{
+			policy: policy,
+			stripped: func(event *SanitizeEvent) {
+				ptty.filterEvents = append(ptty.filterEvents, event)
+			},
+		}
+		return WithOutputFilter(s, TargetTerminal)(ptty)
+	}
+}
+
+// sanitizeIdle is how long the output has to pause before the start of an
+// unfinished string sequence is passed on or stripped, see sanitizer.Flush.
+const sanitizeIdle = 500 * time.Millisecond
+
+// sanitizer is the output filter behind WithSanitizer.
+type sanitizer struct {
+	parser   escapeParser
+	policy   SanitizePolicy
+	stripped func(event *SanitizeEvent)
+
+	// stripping is set while the parts of a stripped long sequence arrive.
+	stripping *SanitizeEvent
+	// passing is set while the parts of an allowed long sequence arrive.
+	passing bool
+	// filteredAt is when the last chunk was filtered.
+	filteredAt time.Time
+}
+
+func (s *sanitizer) Filter(data []byte) []byte {
+	s.filteredAt = time.Now()
+	out := make([]byte, 0, len(data))
+	s.parser.Parse(data, func(seq sequence) {
+		out = s.sanitize(out, seq)
+	})
+	return out
+}
+
+// Flush implements Flusher. Once the output paused for sanitizeIdle, the start
+// of an unfinished string sequence is passed on or stripped like the parts of
+// a long one, as soon as its class is known. Everything else still held back,
+// such as an escape sequence that could turn into one to strip, is only
+// released once the session ends.
+func (s *sanitizer) Flush(final bool) []byte {
+	var out []byte
+	emit := func(seq sequence) {
+		out = s.sanitize(out, seq)
+	}
+
+	if final {
+		s.parser.end(emit)
+		return out
+	}
+
+	if time.Since(s.filteredAt) < sanitizeIdle {
+		return nil
+	}
+	if seq, ok := s.parser.unfinished(); ok && (seq.Continued || classKnown(seq)) {
+		s.parser.split(emit)
+	}
+	return out
+}
+
+// sanitize appends seq to out, unless it is stripped.
+func (s *sanitizer) sanitize(out []byte, seq sequence) []byte {
+	if seq.Continued {
+		if s.stripping != nil {
+			s.stripping.Length += len(seq.Raw)
+			if !seq.Partial {
+				s.stripping = nil
+			}
+			return out
+		}
+		if s.passing {
+			s.passing = seq.Partial
+			return append(out, seq.Raw...)
+		}
+	}
+
+	class, ok := classifySequence(seq)
+	if !ok || s.policy[class] != SanitizeStrip {
+		s.passing = seq.Partial
+		return append(out, seq.Raw...)
+	}
+
+	quote := seq.Raw
+	if len(quote) > maxSanitizeQuote {
+		quote = quote[:maxSanitizeQuote]
+	}
+	event := &SanitizeEvent{
+		AuditHeader: AuditHeader{Type: "sanitized"},
+		Class:       class,
+		Sequence:    strconv.Quote(string(quote)),
+		Length:      len(seq.Raw),
+	}
+	if seq.Partial {
+		s.stripping = event
+	}
+	s.stripped(event)
+	return out
+}
+
+// classKnown reports whether the rest of the unfinished string sequence seq
+// cannot change its class.
+func classKnown(seq sequence) bool {
+	switch seq.Kind {
+	case seqOSC:
+		return bytes.IndexByte(seq.Payload(), ';') >= 0
+	case seqDCS:
+		return len(seq.Payload()) >= 2
+	}
+	return true
+}
+
+// classifySequence returns the class of seq, if it belongs to one.
+func classifySequence(seq sequence) (SequenceClass, bool) {
+	switch seq.Kind {
+	case seqOSC:
+		code, _, _ := bytes.Cut(seq.Payload(), []byte{';'})
+		switch string(code) {
+		case "52":
+			return ClassClipboard, true
+		case "0", "1", "2":
+			return ClassTitle, true
+		case "8":
+			return ClassHyperlinks, true
+		case "50":
+			return ClassFonts, true
+		}
+
+	case seqDCS:
+		payload := seq.Payload()
+		if bytes.HasPrefix(payload, []byte("$q")) || bytes.HasPrefix(payload, []byte("+q")) {
+			return ClassReports, true
+		}
+
+	case seqCSI:
+		params := seq.Raw[2:]
+		if bytes.Equal(params, []byte("20t")) || bytes.Equal(params, []byte("21t")) {
+			return ClassReports, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,207 @@
+package tty
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSanitizer(t *testing.T) {
+	tests := []struct {
+		name     string
+		chunks   []string
+		want     string
+		stripped []SequenceClass
+	}{
+		{
+			name:     "clipboard",
+			chunks:   []string{"a\x1b]52;c;aGVsbG8=\x07b"},
+			want:     "ab",
+			stripped: []SequenceClass{ClassClipboard},
+		},
+		{
+			name:     "split across chunks",
+			chunks:   []string{"a\x1b]5", "2;c;aGVs", "bG8=\x1b\\b"},
+			want:     "ab",
+			stripped: []SequenceClass{ClassClipboard},
+		},
+		{
+			name:     "reports",
+			chunks:   []string{"\x1bP$qm\x1b\\\x1b[21t\x1b[8;24;80t\x1b[1mbold"},
+			want:     "\x1b[8;24;80t\x1b[1mbold",
+			stripped: []SequenceClass{ClassReports, ClassReports},
+		},
+		{
+			name:   "allowed",
+			chunks: []string{"\x1b]0;title\x07\x1b]8;;https://example.com\x07link\x1b]8;;\x07"},
+			want:   "\x1b]0;title\x07\x1b]8;;https://example.com\x07link\x1b]8;;\x07",
+		},
+		{
+			name:     "C1 controls",
+			chunks:   []string{"a\xc2\x9d52;c;aGVsbG8=\xc2\x9cb\xc2\x90$qm\x1b\\\xc2\x9b21tc"},
+			want:     "abc",
+			stripped: []SequenceClass{ClassClipboard, ClassReports, ClassReports},
+		},
+		{
+			name:     "C1 controls split across chunks",
+			chunks:   []string{"a\xc2", "\x9d52;c;aGVsbG8=\xc2", "\x9cb"},
+			want:     "ab",
+			stripped: []SequenceClass{ClassClipboard},
+		},
+		{
+			name:   "Latin-1 supplement",
+			chunks: []string{"20\xc2", "\xb0C \xc2\xa9 \x1b]0;\xc2\xb5s\x07"},
+			want:   "20\xc2\xb0C \xc2\xa9 \x1b]0;\xc2\xb5s\x07",
+		},
+		{
+			name:     "long clipboard",
+			chunks:   []string{"\x1b]52;c;" + strings.Repeat("A", maxSequenceLen*2) + "\x07ok"},
+			want:     "ok",
+			stripped: []SequenceClass{ClassClipboard},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stripped []*SanitizeEvent
+			s := &sanitizer{
+				policy:   DefaultSanitizePolicy(),
+				stripped: func(event *SanitizeEvent) { stripped = append(stripped, event) },
+			}
+
+			var got []byte
+			for _, chunk := range tt.chunks {
+				got = append(got, s.Filter([]byte(chunk))...)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if len(stripped) != len(tt.stripped) {
+				t.Fatalf("got %d stripped sequences, want %d", len(stripped), len(tt.stripped))
+			}
+			for i, class := range tt.stripped {
+				if stripped[i].Class != class {
+					t.Errorf("got class %s, want %s", stripped[i].Class, class)
+				}
+			}
+		})
+	}
+}
+
+func TestSanitizer_Flush(t *testing.T) {
+	// A step is a chunk to filter, or an idle or final flush.
+	const (
+		idle  = "<idle>"
+		final = "<final>"
+	)
+	tests := []struct {
+		name     string
+		steps    []string
+		want     string
+		stripped []SequenceClass
+	}{
+		{
+			name:  "split C1 byte at the end",
+			steps: []string{"20\xc2", idle, final},
+			want:  "20\xc2",
+		},
+		{
+			name:     "unterminated clipboard at the end",
+			steps:    []string{"a\x1b]52;c;aGVs", final},
+			want:     "a",
+			stripped: []SequenceClass{ClassClipboard},
+		},
+		{
+			name:  "unterminated escape sequence at the end",
+			steps: []string{"a\x1b[2", idle, final},
+			want:  "a\x1b[2",
+		},
+		{
+			name:  "unterminated title after a pause",
+			steps: []string{"\x1b]0;ti", idle, "tle\x07b", final},
+			want:  "\x1b]0;title\x07b",
+		},
+		{
+			name:     "unterminated clipboard after a pause",
+			steps:    []string{"a\x1b]52;c;aGVs", idle, "bG8=\x07b", final},
+			want:     "ab",
+			stripped: []SequenceClass{ClassClipboard},
+		},
+		{
+			name:     "code still unknown after a pause",
+			steps:    []string{"a\x1b]5", idle, "2;c;aGVsbG8=\x1b", idle, "\\b", final},
+			want:     "ab",
+			stripped: []SequenceClass{ClassClipboard},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stripped []*SanitizeEvent
+			s := &sanitizer{
+				policy:   DefaultSanitizePolicy(),
+				stripped: func(event *SanitizeEvent) { stripped = append(stripped, event) },
+			}
+
+			var got []byte
+			for _, step := range tt.steps {
+				switch step {
+				case idle:
+					s.filteredAt = s.filteredAt.Add(-sanitizeIdle)
+					got = append(got, s.Flush(false)...)
+				case final:
+					got = append(got, s.Flush(true)...)
+				default:
+					got = append(got, s.Filter([]byte(step))...)
+					got = append(got, s.Flush(false)...)
+				}
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if len(stripped) != len(tt.stripped) {
+				t.Fatalf("got %d stripped sequences, want %d", len(stripped), len(tt.stripped))
+			}
+			for i, class := range tt.stripped {
+				if stripped[i].Class != class {
+					t.Errorf("got class %s, want %s", stripped[i].Class, class)
+				}
+			}
+		})
+	}
+}
+
+func TestParseSanitizePolicy(t *testing.T) {
+	policy, err := ParseSanitizePolicy("title=strip, clipboard=allow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy[ClassTitle] != SanitizeStrip || policy[ClassClipboard] != SanitizeAllow || policy[ClassReports] != SanitizeStrip {
+		t.Errorf("unexpected policy %v", policy)
+	}
+
+	for _, invalid := range []string{"title", "colors=strip", "title=hide"} {
+		if _, err := ParseSanitizePolicy(invalid); err == nil {
+			t.Errorf("%q was accepted", invalid)
+		}
+	}
+}
+
+func TestProxyTTY_SanitizerEOF(t *testing.T) {
+	slave := &testSlave{}
+	slave.WriteString("20\xc2")
+	var master bytes.Buffer
+
+	ptty, err := New(nil, &master, slave, WithSanitizer(DefaultSanitizePolicy()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ptty.Run(context.Background()); !errors.Is(err, ErrSlaveClosed) {
+		t.Errorf("got error %v, want %v", err, ErrSlaveClosed)
+	}
+	if master.String() != "20\xc2" {
+		t.Errorf("master got %q, want %q", master.String(), "20\xc2")
+	}
+}
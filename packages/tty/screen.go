@@ -0,0 +1,101 @@
+package tty
+
+import (
+	"bytes"
+)
+
+// maxScreenBuffer bounds the output kept to replay the screen.
+const maxScreenBuffer = 256 * 1024
+
+var (
+	clearScreen     = []byte("\x1b[H\x1b[2J")
+	enterAltScreen  = []byte("\x1b[?1049h")
+	altScreenModes  = [][]byte{[]byte("?1049"), []byte("?1047"), []byte("?47")}
+	resetTerminal   = []byte("\x1bc")
+	eraseFullScreen = []byte("2J")
+)
+
+// screenBuffer keeps the output needed to redraw the current screen on
+// another terminal. Instead of emulating a terminal, it keeps the output
+// since the screen was last cleared, which most full screen programs and
+// shells do regularly. The main screen is kept aside while the alternate
+// screen is in use.
+type screenBuffer struct {
+	parser    escapeParser
+	buf       []byte
+	main      []byte
+	altScreen bool
+}
+
+// Write adds slave output to the buffer.
+func (s *screenBuffer) Write(data []byte) {
+	s.parser.Parse(data, func(seq sequence) {
+		if seq.Kind == seqEscape && bytes.Equal(seq.Raw, resetTerminal) {
+			s.altScreen = false
+			s.main = nil
+			s.buf = append(s.buf[:0], seq.Raw...)
+			return
+		}
+
+		if seq.Kind == seqCSI && len(seq.Raw) >= 3 {
+			params := seq.Raw[2 : len(seq.Raw)-1]
+			final := seq.Raw[len(seq.Raw)-1]
+
+			switch {
+			case (final == 'h' || final == 'l') && isAltScreenMode(params):
+				s.switchScreen(final == 'h', seq.Raw)
+				return
+			case bytes.Equal(seq.Raw[2:], eraseFullScreen):
+				s.buf = s.buf[:0]
+				if s.altScreen {
+					s.buf = append(s.buf, enterAltScreen...)
+				}
+			}
+		}
+
+		s.buf = append(s.buf, seq.Raw...)
+	})
+
+	if len(s.buf) > maxScreenBuffer {
+		s.buf = trimScreen(s.buf)
+	}
+}
+
+func (s *screenBuffer) switchScreen(alt bool, raw []byte) {
+	switch {
+	case alt && !s.altScreen:
+		s.main = append([]byte(nil), s.buf...)
+		s.buf = append(s.buf[:0], raw...)
+	case !alt && s.altScreen:
+		s.buf = append(s.main, raw...)
+		s.main = nil
+	default:
+		s.buf = append(s.buf, raw...)
+	}
+	s.altScreen = alt
+}
+
+// Replay returns the output that redraws the screen on a cleared terminal.
+func (s *screenBuffer) Replay() []byte {
+	replay := make([]byte, 0, len(clearScreen)+len(s.buf))
+	replay = append(replay, clearScreen...)
+	return append(replay, s.buf...)
+}
+
+func isAltScreenMode(params []byte) bool {
+	for _, mode := range altScreenModes {
+		if bytes.Equal(params, mode) {
+			return true
+		}
+	}
+	return false
+}
+
+// trimScreen drops the older half of buf, starting at a line boundary.
+func trimScreen(buf []byte) []byte {
+	cut := len(buf) / 2
+	if i := bytes.IndexByte(buf[cut:], '\n'); i >= 0 {
+		cut += i + 1
+	}
+	return append(buf[:0], buf[cut:]...)
+}
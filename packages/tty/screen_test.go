@@ -0,0 +1,26 @@
+package tty
+
+import (
+	"testing"
+)
+
+func TestScreenBuffer(t *testing.T) {
+	var screen screenBuffer
+
+	for _, chunk := range []string{
+		"old output\r\n\x1b[H\x1b[2",
+		"J$ vim\r\n",
+		"\x1b[?1049hediting",
+		"\x1b[2Jredrawn",
+	} {
+		screen.Write([]byte(chunk))
+	}
+	if got, want := string(screen.Replay()), "\x1b[H\x1b[2J\x1b[?1049h\x1b[2Jredrawn"; got != want {
+		t.Errorf("expected alternate screen replay %q, got %q", want, got)
+	}
+
+	screen.Write([]byte("\x1b[?1049l$ "))
+	if got, want := string(screen.Replay()), "\x1b[H\x1b[2J\x1b[2J$ vim\r\n\x1b[?1049l$ "; got != want {
+		t.Errorf("expected main screen replay %q, got %q", want, got)
+	}
+}
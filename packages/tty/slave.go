@@ -18,6 +18,16 @@ type Slave interface {
 	Close() error
 }
 
+// ExitReporter is implemented by slaves that can tell how their process exited.
+type ExitReporter interface {
+	// Exited is closed once the process has exited.
+	Exited() <-chan struct{}
+
+	// ExitCode returns the exit code of the process, or -1 if it
+	// has not exited yet or was terminated by a signal.
+	ExitCode() int
+}
+
 type Factory interface {
 	Name() string
 	New(params map[string][]string) (Slave, error)
@@ -0,0 +1,194 @@
+package tty
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// timeoutCheckInterval is how often the session timeouts are checked.
+// It is shortened by the tests.
+var timeoutCheckInterval = 1 * time.Second
+
+// TerminationEvent records why qudosh ended a session.
+type TerminationEvent struct {
+	AuditHeader
+
+	Reason string `json:"reason"`
+}
+
+// WithIdleTimeout terminates the session once there was neither input nor
+// output for timeout. A warning is printed on the master when warning is
+// left before the session gets terminated.
+func WithIdleTimeout(timeout, warning time.Duration) Option {
+	return func(ptty *ProxyTTY) error {
+		if timeout <= 0 {
+			return fmt.Errorf("invalid idle timeout %s", timeout)
+		}
+		if warning < 0 || warning >= timeout {
+			return fmt.Errorf("idle warning %s has to be shorter than the idle timeout %s", warning, timeout)
+		}
+
+		ptty.idleTimeout = timeout
+		ptty.idleWarning = warning
+		return nil
+	}
+}
+
+// ExpiryNotices are the times before the expiry of a session at which
+// a countdown notice is printed on the master.
+var ExpiryNotices = []time.Duration{
+	15 * time.Minute,
+	5 * time.Minute,
+	time.Minute,
+	30 * time.Second,
+	10 * time.Second,
+}
+
+// WithMaxDuration terminates the session once it has been running for d.
+func WithMaxDuration(d time.Duration) Option {
+	return func(ptty *ProxyTTY) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid maximum session duration %s", d)
+		}
+		return WithExpiry(time.Now().Add(d))(ptty)
+	}
+}
+
+// WithExpiry terminates the session at deadline. When combined with
+// WithMaxDuration or given several times, the earliest deadline wins.
+func WithExpiry(deadline time.Time) Option {
+	return func(ptty *ProxyTTY) error {
+		if !deadline.After(time.Now()) {
+			return ErrSessionExpired
+		}
+
+		if ptty.expiresAt.IsZero() || deadline.Before(ptty.expiresAt) {
+			ptty.expiresAt = deadline
+		}
+		return nil
+	}
+}
+
+// watchExpiry prints countdown notices and terminates the session at expiresAt.
+func (ptty *ProxyTTY) watchExpiry(ctx context.Context) error {
+	ticker := time.NewTicker(timeoutCheckInterval)
+	defer ticker.Stop()
+
+	// Notices that are already due when the session starts are skipped.
+	next := 0
+	for next < len(ExpiryNotices) && time.Until(ptty.expiresAt) <= ExpiryNotices[next] {
+		next++
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		left := time.Until(ptty.expiresAt)
+		if left <= 0 {
+			reason := fmt.Sprintf("session expired at %s", ptty.expiresAt.Format(time.RFC3339))
+			ptty.masterWrite([]byte("\r\nqudosh: " + reason + ", terminating\r\n"))
+			return ptty.terminate(reason, ErrSessionExpired)
+		}
+
+		notice := false
+		for next < len(ExpiryNotices) && left <= ExpiryNotices[next] {
+			next++
+			notice = true
+		}
+		if notice {
+			ptty.masterWrite([]byte(fmt.Sprintf(
+				"\r\nqudosh: session expires in %s\r\n", left.Round(time.Second),
+			)))
+		}
+	}
+}
+
+// touch records activity on the session.
+func (ptty *ProxyTTY) touch() {
+	ptty.pingMutex.Lock()
+	ptty.lastPingTime = time.Now()
+	ptty.pingMutex.Unlock()
+}
+
+func (ptty *ProxyTTY) idleSince() time.Duration {
+	ptty.pingMutex.Lock()
+	defer ptty.pingMutex.Unlock()
+
+	return time.Since(ptty.lastPingTime)
+}
+
+// watchIdle terminates the session after idleTimeout without activity.
+func (ptty *ProxyTTY) watchIdle(ctx context.Context) error {
+	ticker := time.NewTicker(timeoutCheckInterval)
+	defer ticker.Stop()
+
+	warned := false
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		idle := ptty.idleSince()
+		switch {
+		case idle >= ptty.idleTimeout:
+			reason := fmt.Sprintf("idle for %s", ptty.idleTimeout)
+			ptty.masterWrite([]byte("\r\nqudosh: session terminated, " + reason + "\r\n"))
+			return ptty.terminate(reason, ErrSessionIdle)
+
+		case idle >= ptty.idleTimeout-ptty.idleWarning:
+			if !warned {
+				warned = true
+				left := (ptty.idleTimeout - idle).Round(time.Second)
+				ptty.masterWrite([]byte(fmt.Sprintf(
+					"\r\nqudosh: session idle, it will be terminated in %s without activity\r\n", left,
+				)))
+			}
+
+		default:
+			warned = false
+		}
+	}
+}
+
+// terminate ends the session for reason: it is recorded in the audit log
+// and the metadata, then the slave is closed with its close signal.
+// It returns err, which Run returns to its caller. The slave is closed in
+// the background, as that waits for the shell to exit and terminate may be
+// called with outputMutex held, see recordingError.
+func (ptty *ProxyTTY) terminate(reason string, err error) error {
+	ptty.pingMutex.Lock()
+	if ptty.terminated != nil {
+		ptty.pingMutex.Unlock()
+		return ptty.terminated
+	}
+	ptty.terminated = err
+	ptty.pingMutex.Unlock()
+
+	ptty.audit(&TerminationEvent{
+		AuditHeader: AuditHeader{Type: "termination", Time: time.Now()},
+		Reason:      reason,
+	})
+	if ptty.logger != nil {
+		ptty.logger.UpdateMetadata(func(m *Metadata) {
+			m.TerminationReason = reason
+		})
+	}
+
+	go ptty.slave.Close()
+	return err
+}
+
+// terminationError returns the error passed to terminate, if any.
+func (ptty *ProxyTTY) terminationError() error {
+	ptty.pingMutex.Lock()
+	defer ptty.pingMutex.Unlock()
+
+	return ptty.terminated
+}
@@ -0,0 +1,225 @@
+package tty
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fastTimeouts checks the timeouts every few milliseconds during a test.
+func fastTimeouts(t *testing.T) {
+	interval := timeoutCheckInterval
+	timeoutCheckInterval = 5 * time.Millisecond
+	t.Cleanup(func() { timeoutCheckInterval = interval })
+}
+
+func TestProxyTTY_WatchIdle(t *testing.T) {
+	fastTimeouts(t)
+
+	tests := []struct {
+		name    string
+		active  bool
+		wantErr error
+	}{
+		{name: "idle", wantErr: ErrSessionIdle},
+		{name: "active", active: true, wantErr: context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			slave := &testSlave{}
+			ptty, err := New(nil, &stdout, slave, WithPermitWrite(), WithIdleTimeout(100*time.Millisecond, 50*time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+			defer cancel()
+			if tt.active {
+				ticker := time.NewTicker(20 * time.Millisecond)
+				defer ticker.Stop()
+				go func() {
+					for range ticker.C {
+						ptty.touch()
+					}
+				}()
+			}
+
+			if err := ptty.watchIdle(ctx); err != tt.wantErr {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if closed := slave.isClosed(100 * time.Millisecond); closed != !tt.active {
+				t.Errorf("slave closed %v, want %v", closed, !tt.active)
+			}
+			if tt.active {
+				return
+			}
+			for _, want := range []string{"session idle, it will be terminated in", "session terminated, idle for 100ms"} {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("output %q lacks %q", stdout.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestProxyTTY_WatchExpiry(t *testing.T) {
+	fastTimeouts(t)
+	notices := ExpiryNotices
+	ExpiryNotices = []time.Duration{time.Hour, 100 * time.Millisecond}
+	defer func() { ExpiryNotices = notices }()
+
+	var stdout bytes.Buffer
+	slave := &testSlave{}
+	ptty, err := New(nil, &stdout, slave, WithMaxDuration(200*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ptty.watchExpiry(context.Background()); err != ErrSessionExpired {
+		t.Fatalf("got %v, want %v", err, ErrSessionExpired)
+	}
+	if !slave.isClosed(time.Second) {
+		t.Error("slave not closed")
+	}
+	// The notice due when the session started is skipped.
+	if n := strings.Count(stdout.String(), "session expires in"); n != 1 {
+		t.Errorf("got %d notices in %q, want 1", n, stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "session expired at") {
+		t.Errorf("output %q lacks the expiry", stdout.String())
+	}
+}
+
+func TestWithExpiry(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		options []Option
+		want    time.Time
+		wantErr error
+	}{
+		{
+			name:    "past deadline",
+			options: []Option{WithExpiry(now.Add(-time.Minute))},
+			wantErr: ErrSessionExpired,
+		},
+		{
+			name:    "earliest deadline wins",
+			options: []Option{WithExpiry(now.Add(3 * time.Hour)), WithExpiry(now.Add(2 * time.Hour)), WithExpiry(now.Add(4 * time.Hour))},
+			want:    now.Add(2 * time.Hour),
+		},
+		{
+			name:    "maximum duration first",
+			options: []Option{WithExpiry(now.Add(2 * time.Hour)), WithMaxDuration(time.Hour)},
+			want:    now.Add(time.Hour),
+		},
+		{
+			name:    "deadline first",
+			options: []Option{WithMaxDuration(3 * time.Hour), WithExpiry(now.Add(2 * time.Hour))},
+			want:    now.Add(2 * time.Hour),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ptty, err := New(nil, &bytes.Buffer{}, &testSlave{}, tt.options...)
+			if err != tt.wantErr {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if d := ptty.expiresAt.Sub(tt.want); d < -time.Second || d > time.Second {
+				t.Errorf("expires at %s, want %s", ptty.expiresAt, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyTTY_Terminate(t *testing.T) {
+	dir := t.TempDir()
+	slave := &testSlave{}
+	ptty, err := New(nil, &bytes.Buffer{}, slave,
+		WithTtyRecording(context.Background(), dir, "session.ttyrec", nil, WithMetadata(&Metadata{SessionID: "s1"})),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ptty.terminate("idle for 1m0s", ErrSessionIdle); err != ErrSessionIdle {
+		t.Errorf("got %v, want %v", err, ErrSessionIdle)
+	}
+	// Only the first reason counts.
+	if err := ptty.terminate("session expired", ErrSessionExpired); err != ErrSessionIdle {
+		t.Errorf("got %v, want %v", err, ErrSessionIdle)
+	}
+	if closed := slave.isClosed(time.Second); !closed || ptty.terminationError() != ErrSessionIdle {
+		t.Errorf("session not terminated: closed %v, error %v", closed, ptty.terminationError())
+	}
+
+	if err := ptty.logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "session.ttyrec.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m Metadata
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.TerminationReason != "idle for 1m0s" {
+		t.Errorf("got termination reason %q", m.TerminationReason)
+	}
+
+	audit, err := os.ReadFile(filepath.Join(dir, "session.ttyrec.audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(audit), `"type":"termination"`); n != 1 {
+		t.Errorf("got %d termination events, want 1", n)
+	}
+}
+
+// hangingSlave takes until release is closed to close, like a shell ignoring its close signal.
+type hangingSlave struct {
+	testSlave
+	release chan struct{}
+}
+
+func (s *hangingSlave) Close() error {
+	<-s.release
+	return s.testSlave.Close()
+}
+
+func TestProxyTTY_TerminateHangingSlave(t *testing.T) {
+	slave := &hangingSlave{release: make(chan struct{})}
+	defer close(slave.release)
+	ptty, err := New(nil, &bytes.Buffer{}, slave)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	terminated := make(chan error, 1)
+	go func() {
+		ptty.outputMutex.Lock()
+		defer ptty.outputMutex.Unlock()
+		terminated <- ptty.terminate("the recording cannot keep up with the output", ErrRecordingStalled)
+	}()
+	select {
+	case err := <-terminated:
+		if err != ErrRecordingStalled {
+			t.Errorf("got %v, want %v", err, ErrRecordingStalled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("terminate waited for the slave to close")
+	}
+}
@@ -0,0 +1,105 @@
+package tty
+
+import (
+	"bytes"
+	"time"
+)
+
+// TitleTarget is the title an OSC 0, 1 or 2 sequence sets.
+type TitleTarget string
+
+const (
+	// TitleBoth is set by OSC 0.
+	TitleBoth TitleTarget = "both"
+	// TitleIcon is set by OSC 1.
+	TitleIcon TitleTarget = "icon"
+	// TitleWindow is set by OSC 2.
+	TitleWindow TitleTarget = "window"
+)
+
+// TitleEvent records a change of the title by the slave, at the frame
+// of the recording that contains it.
+type TitleEvent struct {
+	AuditHeader
+
+	Title  string        `json:"title"`
+	Target TitleTarget   `json:"target"`
+	Frame  FramePosition `json:"frame"`
+}
+
+// titleTracker follows the window and icon title set in the slave output.
+type titleTracker struct {
+	parser escapeParser
+}
+
+// Feed parses output and calls changed for every title sequence within it.
+// Titles too long for the parser to buffer are ignored.
+func (t *titleTracker) Feed(data []byte, changed func(target TitleTarget, title []byte)) {
+	t.parser.Parse(data, func(seq sequence) {
+		if seq.Kind != seqOSC || seq.Partial || seq.Continued {
+			return
+		}
+
+		code, title, ok := bytes.Cut(seq.Payload(), []byte{';'})
+		if !ok {
+			return
+		}
+		switch string(code) {
+		case "0":
+			changed(TitleBoth, title)
+		case "1":
+			changed(TitleIcon, title)
+		case "2":
+			changed(TitleWindow, title)
+		}
+	})
+}
+
+// trackTitle updates the titles of the session from output written to
+// the recording at pos. It must be called with writeMutex held.
+func (ptty *ProxyTTY) trackTitle(data []byte, pos FramePosition) {
+	ptty.titles.Feed(data, func(target TitleTarget, title []byte) {
+		changed := false
+		if target != TitleIcon && !bytes.Equal(ptty.windowTitle, title) {
+			ptty.windowTitle = append(ptty.windowTitle[:0], title...)
+			changed = true
+		}
+		if target != TitleWindow && !bytes.Equal(ptty.iconTitle, title) {
+			ptty.iconTitle = append(ptty.iconTitle[:0], title...)
+			changed = true
+		}
+
+		if changed {
+			ptty.audit(&TitleEvent{
+				AuditHeader: AuditHeader{Type: "title", Time: time.Now()},
+				Title:       string(title),
+				Target:      target,
+				Frame:       pos,
+			})
+		}
+	})
+}
+
+// WindowTitle returns the window title last set by the slave.
+func (ptty *ProxyTTY) WindowTitle() string {
+	ptty.writeMutex.Lock()
+	defer ptty.writeMutex.Unlock()
+
+	return string(ptty.windowTitle)
+}
+
+// WindowTitleVariables returns the variables of the slave along with the
+// current "title" and "icon_title", e.g. to name the terminal of a master.
+func (ptty *ProxyTTY) WindowTitleVariables() map[string]interface{} {
+	variables := map[string]interface{}{}
+	for name, value := range ptty.slave.WindowTitleVariables() {
+		variables[name] = value
+	}
+
+	ptty.writeMutex.Lock()
+	variables["title"] = string(ptty.windowTitle)
+	variables["icon_title"] = string(ptty.iconTitle)
+	ptty.writeMutex.Unlock()
+
+	return variables
+}
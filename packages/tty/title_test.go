@@ -0,0 +1,37 @@
+package tty
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestProxyTTY_WindowTitle(t *testing.T) {
+	var stdout bytes.Buffer
+	ptty, err := New(nil, &stdout, &testSlave{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		output string
+		window string
+		icon   string
+	}{
+		{"\x1b]0;user@host: ~\x07$ ", "user@host: ~", "user@host: ~"},
+		{"\x1b]2;vim READ", "user@host: ~", "user@host: ~"},
+		{"ME.md\x1b\\", "vim README.md", "user@host: ~"},
+		{"\x1b]1;vim\x07", "vim README.md", "vim"},
+		{"\x1b]7;file://host/tmp\x07", "vim README.md", "vim"},
+	}
+	for _, tt := range tests {
+		if err := ptty.handleSlaveReadEvent([]byte(tt.output)); err != nil {
+			t.Fatal(err)
+		}
+		if title := ptty.WindowTitle(); title != tt.window {
+			t.Errorf("after %q: got window title %q, want %q", tt.output, title, tt.window)
+		}
+		if icon := ptty.WindowTitleVariables()["icon_title"]; icon != tt.icon {
+			t.Errorf("after %q: got icon title %q, want %q", tt.output, icon, tt.icon)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package tty
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// watcherQueueSize is the number of messages buffered per client.
+	// Clients falling further behind are disconnected.
+	watcherQueueSize = 256
+
+	// helloTimeout is how long a client has to introduce itself.
+	helloTimeout = 5 * time.Second
+
+	// closeTimeout is how long closing waits for clients to get the last messages.
+	closeTimeout = time.Second
+)
+
+// ErrWatcherBehind is returned when writing to a client that fell behind.
+var ErrWatcherBehind = errors.New("watcher fell behind")
+
+// WithWatchSocket exposes the session on a Unix socket at path with the given
+// permissions. Any number of clients can connect to it: they get the current
+// screen followed by the live output. Clients joining with ModeJoin may also
+// write to the session if allowed by WithSharedWrite.
+func WithWatchSocket(path string, mode os.FileMode) Option {
+	return func(ptty *ProxyTTY) error {
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to listen on %s", path)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			listener.Close()
+			return errors.Wrapf(err, "failed to set permissions of %s", path)
+		}
+
+		ptty.screen = &screenBuffer{}
+		ptty.watchers = &watchServer{
+			ptty:     ptty,
+			listener: listener,
+			clients:  map[*watcher]struct{}{},
+		}
+		return nil
+	}
+}
+
+// WithSharedWrite allows the listed users to write to the session when they
+// join through the session socket. The user is taken from the credentials of
+// the socket peer where the platform provides them.
+func WithSharedWrite(users ...string) Option {
+	return func(ptty *ProxyTTY) error {
+		ptty.sharedWriters = users
+		return nil
+	}
+}
+
+// watchServer attaches the clients of the session socket as masters.
+type watchServer struct {
+	ptty     *ProxyTTY
+	listener net.Listener
+
+	mu      sync.Mutex
+	clients map[*watcher]struct{}
+	closed  bool
+
+	handlers sync.WaitGroup
+}
+
+// watcher is the Stdout of a master attached through the session socket.
+// Writes are queued so that a slow client never stalls the session.
+type watcher struct {
+	conn  net.Conn
+	hello Hello
+
+	mu     sync.Mutex
+	queue  chan message
+	closed bool
+}
+
+type message struct {
+	t       MessageType
+	payload []byte
+}
+
+func (w *watcher) send(m message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return net.ErrClosed
+	}
+	select {
+	case w.queue <- m:
+		return nil
+	default:
+		w.closed = true
+		close(w.queue)
+		return ErrWatcherBehind
+	}
+}
+
+func (w *watcher) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+}
+
+// Write queues terminal output for the client.
+func (w *watcher) Write(data []byte) (int, error) {
+	payload := make([]byte, len(data))
+	copy(payload, data)
+
+	if err := w.send(message{MessageOutput, payload}); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Resize queues the new terminal size for the client.
+func (w *watcher) Resize(size TerminalSize) {
+	w.send(message{MessageResize, EncodeSize(size)})
+}
+
+// Serve accepts clients until Close is called.
+func (s *watchServer) Serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *watchServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	t, payload, err := ReadMessage(conn)
+	if err != nil || t != MessageHello {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	var hello Hello
+	if err := json.Unmarshal(payload, &hello); err != nil {
+		return
+	}
+	if hello.Mode != ModeWatch && hello.Mode != ModeJoin && hello.Mode != ModeAttach && hello.Mode != ModeControl {
+		return
+	}
+	user, verified := peerUser(conn)
+	if verified {
+		hello.User = user
+	}
+	if hello.Mode == ModeControl {
+		s.handleControl(conn, hello, verified)
+		return
+	}
+
+	w := &watcher{
+		conn:  conn,
+		hello: hello,
+		queue: make(chan message, watcherQueueSize),
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.clients[w] = struct{}{}
+	s.handlers.Add(1)
+	s.mu.Unlock()
+
+	defer s.handlers.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, w)
+		s.mu.Unlock()
+	}()
+
+	// Write access depends on who the client is, which only the credentials
+	// of the socket peer tell.
+	m := &Master{Name: hello.User, Stdout: w}
+	switch hello.Mode {
+	case ModeJoin:
+		m.PermitWrite = verified && containsString(s.ptty.sharedWriters, hello.User)
+	case ModeAttach:
+		m.PermitWrite = verified && s.ptty.owner.PermitWrite && hello.User == s.ptty.owner.Name
+	}
+	s.ptty.Attach(m, true)
+	defer s.ptty.Detach(m)
+
+	// Input is read until the client goes away, watchers' input is discarded.
+	// Only the owner attaching to the session resizes it.
+	go func() {
+		defer w.close()
+		for {
+			t, payload, err := ReadMessage(conn)
+			if err != nil {
+				return
+			}
+			switch t {
+			case MessageInput:
+				s.ptty.Input(m, payload)
+			case MessageResize:
+				size, err := DecodeSize(payload)
+				if err != nil || hello.Mode != ModeAttach || !m.PermitWrite {
+					continue
+				}
+				s.ptty.ResizeEvents <- &ArgResizeTerminal{Columns: size.Columns, Rows: size.Rows}
+			}
+		}
+	}()
+
+	for msg := range w.queue {
+		if err := WriteMessage(conn, msg.t, msg.payload); err != nil {
+			w.close()
+			break
+		}
+	}
+}
+
+// Close stops accepting clients, disconnects the current ones and removes the socket.
+// The clients are told why the session ended.
+func (s *watchServer) Close() error {
+	err := s.listener.Close()
+
+	reason := "session ended"
+	if terminated := s.ptty.terminationError(); terminated != nil {
+		reason = terminated.Error()
+	}
+
+	s.mu.Lock()
+	s.closed = true
+	for w := range s.clients {
+		w.send(message{MessageClose, []byte(reason)})
+		w.close()
+	}
+	s.mu.Unlock()
+
+	flushed := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+	case <-time.After(closeTimeout):
+	}
+
+	return err
+}
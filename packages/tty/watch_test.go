@@ -0,0 +1,250 @@
+package tty
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pipeSlave is a slave whose output is written by the test while the session runs.
+type pipeSlave struct {
+	*io.PipeReader
+	output *io.PipeWriter
+
+	mu    sync.Mutex
+	input bytes.Buffer
+}
+
+func newPipeSlave() *pipeSlave {
+	r, w := io.Pipe()
+	return &pipeSlave{PipeReader: r, output: w}
+}
+
+func (s *pipeSlave) Write(data []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.input.Write(data)
+}
+
+func (s *pipeSlave) Input() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.input.String()
+}
+
+func (s *pipeSlave) WindowTitleVariables() map[string]interface{} { return nil }
+func (s *pipeSlave) ResizeTerminal(columns int, rows int) error   { return nil }
+func (s *pipeSlave) Close() error                                 { return s.output.Close() }
+
+func TestWatchSocket(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("peer credentials are not supported")
+	}
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		mode      SessionMode
+		wantInput string
+	}{
+		{name: "watcher", mode: ModeWatch},
+		{name: "shared writer", mode: ModeJoin, wantInput: "ls\r"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "session.sock")
+			slave := newPipeSlave()
+
+			events := make(chan string, 64)
+			observer := ObserverFunc(func(event Event) error {
+				switch e := event.(type) {
+				case *OutputEvent:
+					events <- "output " + string(e.Live)
+				case *ParticipantEvent:
+					events <- e.Type
+				}
+				return nil
+			})
+			ptty, err := New(nil, nil, slave,
+				WithPermitWrite(),
+				WithOwner("alice"),
+				WithSharedWrite(current.Username),
+				WithWatchSocket(path, 0o600),
+				WithObserver(observer),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan error, 1)
+			go func() {
+				done <- ptty.Run(context.Background())
+			}()
+			defer func() {
+				slave.Close()
+				<-done
+			}()
+
+			await := func(want string) {
+				t.Helper()
+				for {
+					select {
+					case event := <-events:
+						if event == want {
+							return
+						}
+					case <-time.After(time.Second):
+						t.Fatalf("no %q", want)
+					}
+				}
+			}
+
+			slave.output.Write([]byte("before\r\n"))
+			await("output before\r\n")
+
+			conn, err := net.Dial("unix", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if err := WriteHello(conn, Hello{Mode: tt.mode}); err != nil {
+				t.Fatal(err)
+			}
+			await("participant_attach")
+
+			output := make(chan string, 16)
+			go func() {
+				defer close(output)
+				for {
+					msgType, payload, err := ReadMessage(conn)
+					if err != nil {
+						return
+					}
+					if msgType == MessageOutput {
+						output <- string(payload)
+					}
+				}
+			}()
+			read := func(want string) {
+				t.Helper()
+				var got string
+				for !strings.Contains(got, want) {
+					select {
+					case data, ok := <-output:
+						if !ok {
+							t.Fatalf("connection closed, got %q, want %q", got, want)
+						}
+						got += data
+					case <-time.After(time.Second):
+						t.Fatalf("got %q, want %q", got, want)
+					}
+				}
+			}
+
+			read("before")
+			slave.output.Write([]byte("after"))
+			read("after")
+
+			// The client is only detached once its input before closing is handled.
+			if err := WriteMessage(conn, MessageInput, []byte("ls\r")); err != nil {
+				t.Fatal(err)
+			}
+			conn.Close()
+			await("participant_detach")
+
+			if got := slave.Input(); got != tt.wantInput {
+				t.Errorf("slave got %q, want %q", got, tt.wantInput)
+			}
+		})
+	}
+}
+
+func TestWatchServer_PermitWrite(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		mode    SessionMode
+		pipe    bool
+		owner   string
+		writers []string
+		want    bool
+	}{
+		{name: "owner attaching", mode: ModeAttach, owner: current.Username, want: true},
+		{name: "other user attaching", mode: ModeAttach, owner: "alice"},
+		{name: "unverified owner attaching", mode: ModeAttach, pipe: true, owner: "alice"},
+		{name: "watcher", mode: ModeWatch, owner: "alice", writers: []string{current.Username}},
+		{name: "shared writer joining", mode: ModeJoin, owner: "alice", writers: []string{current.Username}, want: true},
+		{name: "other user joining", mode: ModeJoin, owner: "alice", writers: []string{"bob"}},
+		{name: "unverified writer joining", mode: ModeJoin, pipe: true, owner: "alice", writers: []string{"alice"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.pipe && runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+				t.Skip("peer credentials are not supported")
+			}
+
+			attached := make(chan *ParticipantEvent, 1)
+			observer := ObserverFunc(func(event Event) error {
+				if e, ok := event.(*ParticipantEvent); ok && e.Type == "participant_attach" {
+					attached <- e
+				}
+				return nil
+			})
+			// A headless session, as run in the background of a detachable one.
+			ptty, err := New(nil, nil, &testSlave{},
+				WithPermitWrite(),
+				WithOwner(tt.owner),
+				WithSharedWrite(tt.writers...),
+				WithObserver(observer),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &watchServer{ptty: ptty, clients: map[*watcher]struct{}{}}
+
+			var client, server net.Conn
+			if tt.pipe {
+				client, server = net.Pipe()
+				defer client.Close()
+			} else {
+				client, server = socketPair(t)
+			}
+			go s.handle(server)
+
+			// Clients without credentials claim to be whoever may write.
+			go WriteHello(client, Hello{Mode: tt.mode, User: "alice"})
+			go func() {
+				for {
+					if _, _, err := ReadMessage(client); err != nil {
+						return
+					}
+				}
+			}()
+
+			select {
+			case event := <-attached:
+				if event.PermitWrite != tt.want {
+					t.Errorf("%s attached with write access %v, want %v", event.Participant, event.PermitWrite, tt.want)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("client not attached")
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package tty
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// MessageType identifies a message exchanged over a session socket.
+type MessageType byte
+
+const (
+	// MessageHello is the first message of a client, a JSON encoded Hello.
+	MessageHello MessageType = 'h'
+	// MessageOutput carries terminal output of the slave.
+	MessageOutput MessageType = 'o'
+	// MessageResize carries the terminal size of the session, see EncodeSize.
+	MessageResize MessageType = 'r'
+	// MessageInput carries keystrokes of a client that joined the session.
+	MessageInput MessageType = 'i'
+	// MessageClose tells the client that the session ended, with the reason.
+	MessageClose MessageType = 'c'
+	// MessageControl carries a JSON encoded ControlRequest of a supervisor
+	// and the ControlReply to it.
+	MessageControl MessageType = 'x'
+)
+
+// maxMessageLen bounds the payload of a single message.
+const maxMessageLen = 16 * 1024 * 1024
+
+// ErrMessageTooLong is returned when reading a message with an oversized payload.
+var ErrMessageTooLong = errors.New("message too long")
+
+// SessionMode is the way a client takes part in a session.
+type SessionMode string
+
+const (
+	// ModeWatch streams the session read-only.
+	ModeWatch SessionMode = "watch"
+	// ModeJoin takes part in the session, with write access if permitted.
+	ModeJoin SessionMode = "join"
+	// ModeAttach takes the place of the owner of a headless session: the
+	// owner writes to the session and its terminal size is applied.
+	ModeAttach SessionMode = "attach"
+	// ModeControl lets a supervisor intervene in the session, see ProxyTTY.Control.
+	ModeControl SessionMode = "control"
+)
+
+// Hello opens a connection to a session socket. Where the platform provides
+// the credentials of Unix socket peers, they take precedence over User.
+// Without them, clients get neither write access nor ModeControl.
+type Hello struct {
+	Mode SessionMode `json:"mode"`
+	User string      `json:"user,omitempty"`
+}
+
+// WriteMessage writes a message of type t: the type byte, the
+// big endian payload length and the payload.
+func WriteMessage(w io.Writer, t MessageType, payload []byte) error {
+	header := make([]byte, 5, 5+len(payload))
+	header[0] = byte(t)
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+
+	_, err := w.Write(append(header, payload...))
+	return err
+}
+
+// ReadMessage reads a message written by WriteMessage.
+func ReadMessage(r io.Reader) (MessageType, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	n := binary.BigEndian.Uint32(header[1:])
+	if n > maxMessageLen {
+		return 0, nil, ErrMessageTooLong
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+
+	return MessageType(header[0]), payload, nil
+}
+
+// WriteHello sends the hello message opening a connection.
+func WriteHello(w io.Writer, hello Hello) error {
+	data, err := json.Marshal(hello)
+	if err != nil {
+		return err
+	}
+	return WriteMessage(w, MessageHello, data)
+}
+
+// EncodeSize encodes a terminal size as the payload of a MessageResize.
+func EncodeSize(size TerminalSize) []byte {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint16(payload, uint16(size.Columns))
+	binary.BigEndian.PutUint16(payload[2:], uint16(size.Rows))
+	return payload
+}
+
+// DecodeSize decodes the payload of a MessageResize.
+func DecodeSize(payload []byte) (TerminalSize, error) {
+	if len(payload) != 4 {
+		return TerminalSize{}, errors.Errorf("invalid resize payload of %d bytes", len(payload))
+	}
+	return TerminalSize{
+		Columns: int(binary.BigEndian.Uint16(payload)),
+		Rows:    int(binary.BigEndian.Uint16(payload[2:])),
+	}, nil
+}
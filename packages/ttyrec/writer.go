@@ -31,6 +31,12 @@ func NewEncoderWithTime(w io.Writer, startedAt time.Time) *Encoder {
 }
 
 func (e *Encoder) Write(p []byte) (int, error) {
+	return e.WriteAt(p, time.Now())
+}
+
+// WriteAt writes p as a frame that happened at t, e.g. for output that was
+// buffered before being written.
+func (e *Encoder) WriteAt(p []byte, t time.Time) (int, error) {
 	if len(p) == 0 {
 		return 0, nil
 	}
@@ -38,9 +44,9 @@ func (e *Encoder) Write(p []byte) (int, error) {
 	header := Header{Len: uint32(len(p))}
 	if !e.started {
 		e.started = true
-		e.startedAt = time.Now()
-	} else {
-		header.Time.Set(time.Since(e.startedAt))
+		e.startedAt = t
+	} else if t.After(e.startedAt) {
+		header.Time.Set(t.Sub(e.startedAt))
 	}
 
 	// Write header.
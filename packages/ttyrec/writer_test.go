@@ -63,3 +63,31 @@ func TestEncoder(t *testing.T) {
 		t.Fatalf("%d frames did not read", len(parts))
 	}
 }
+
+func TestEncoder_WriteAt(t *testing.T) {
+	var (
+		buf   bytes.Buffer
+		enc   = NewEncoder(&buf)
+		dec   = NewDecoder(&buf)
+		start = time.Now()
+	)
+	for _, offset := range []time.Duration{0, 1500 * time.Millisecond, time.Second} {
+		if _, err := enc.WriteAt([]byte("x"), start.Add(offset)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var first *Frame
+	for i, want := range []time.Duration{0, 1500 * time.Millisecond, time.Second} {
+		frame, err := dec.DecodeFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first == nil {
+			first = frame
+		}
+		if got := frame.Time.Sub(first.Time); got != want {
+			t.Errorf("frame %d at %s, expected %s", i, got, want)
+		}
+	}
+}
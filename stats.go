@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/x-qdo/qudosh/packages/stats"
+)
+
+// statsCommand reports the activity of recorded sessions from their metrics:
+// qudosh stats [-json] [path...]. Without paths, the recordings under
+// LOCAL_PREFIX are analysed.
+func statsCommand(args []string) int {
+	flags := flag.NewFlagSet("stats", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: qudosh stats [-json] [path...]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		prefix := os.Getenv("LOCAL_PREFIX")
+		if prefix == "" {
+			prefix = "."
+		}
+		paths = []string{filepath.Join(prefix, "lab")}
+	}
+
+	sessions, skipped, err := stats.Load(paths...)
+	if err != nil {
+		return exit(err, 1)
+	}
+	for _, name := range skipped {
+		fmt.Fprintf(os.Stderr, "Warning: skipped %s: %s\n", name, stats.ErrNotMetrics)
+	}
+	report := stats.Analyze(sessions)
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return exit(err, 1)
+		}
+		return 0
+	}
+
+	printReport(os.Stdout, report)
+	return 0
+}
+
+func printReport(out io.Writer, report *stats.Report) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	header := "ACTIVE\tIDLE\tLONGEST IDLE\tBURSTS\tLONGEST BURST\tKEYSTROKES\tINPUT BYTES\tOUTPUT BYTES"
+	row := func(t stats.Totals) string {
+		return fmt.Sprintf("%s\t%s\t%s\t%d\t%s\t%d\t%d\t%d",
+			t.Active, t.Idle, t.LongestIdle, t.Bursts, t.LongestBurst, t.Keystrokes, t.InputBytes, t.OutputBytes)
+	}
+
+	fmt.Fprintln(w, "SESSION\tUSER\tSTARTED\tSEGMENTS\t"+header)
+	for _, s := range report.Sessions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", s.ID, s.User, s.StartedAt.Format("2006-01-02 15:04"), s.Segments, row(s.Totals))
+	}
+	fmt.Fprintf(w, "TOTAL\t\t\t\t%s\n\n", row(report.Total))
+
+	fmt.Fprintln(w, "USER\tSESSIONS\t"+header)
+	for _, u := range report.Users {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", u.User, u.Sessions, row(u.Totals))
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "DAY (UTC)\tSESSIONS\t"+header)
+	for _, d := range report.Days {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", d.Day, d.Sessions, row(d.Totals))
+	}
+	w.Flush()
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/creack/pty"
+	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/x-qdo/qudosh/packages/tty"
+)
+
+// detachKey detaches from a watched, joined or attached session (Ctrl-]).
+const detachKey = 0x1d
+
+// socketDir returns the directory holding the session sockets of the current
+// user. Every user has their own, so that nobody can place a socket where
+// another user's session or client expects one.
+func socketDir() string {
+	if dir := os.Getenv("QUDOSH_SOCKET_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("qudosh-%d", os.Getuid()))
+}
+
+// makeSocketDir creates the socket directory. Other users may traverse it to
+// reach the sockets they are allowed to, but not list or change it.
+func makeSocketDir() (string, error) {
+	dir := socketDir()
+	if err := os.MkdirAll(dir, 0o711); err != nil {
+		return "", err
+	}
+	return dir, checkSocketDir(dir)
+}
+
+// checkSocketDir refuses a socket directory that is a symlink, belongs to
+// another user or can be written by other users.
+func checkSocketDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("socket directory %s is a symlink", dir)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("socket directory %s is not a directory", dir)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("socket directory %s is not owned by the current user", dir)
+	}
+	if info.Mode().Perm()&0o022 != 0 {
+		return fmt.Errorf("socket directory %s is writable by other users", dir)
+	}
+	return nil
+}
+
+// sessionSocket resolves a session ID or a socket path to a socket path. IDs
+// are looked up in the socket directory of the current user; the sessions of
+// other users are reached by the path of their socket.
+func sessionSocket(session string) (string, error) {
+	if strings.ContainsRune(session, os.PathSeparator) {
+		return session, nil
+	}
+	if err := checkSocketDir(socketDir()); err != nil {
+		return "", err
+	}
+	return filepath.Join(socketDir(), session+".sock"), nil
+}
+
+// listSessions prints the IDs of the sessions with a socket.
+func listSessions() int {
+	if err := checkSocketDir(socketDir()); err != nil {
+		if os.IsNotExist(err) {
+			return 0
+		}
+		return exit(err, 1)
+	}
+
+	sockets, err := filepath.Glob(filepath.Join(socketDir(), "*.sock"))
+	if err != nil {
+		return exit(err, 1)
+	}
+	for _, socket := range sockets {
+		fmt.Println(strings.TrimSuffix(filepath.Base(socket), ".sock"))
+	}
+	return 0
+}
+
+// socketOptions exposes the session on its socket, with the permissions of
+// QUDOSH_SOCKET_MODE (octal, 0600 by default), write access for the comma
+// separated users of QUDOSH_SHARED_WRITERS and control over the session for
+// the ones of QUDOSH_SUPERVISORS.
+func socketOptions(sessionID string) ([]tty.Option, error) {
+	dir, err := makeSocketDir()
+	if err != nil {
+		return nil, err
+	}
+
+	mode := os.FileMode(0o600)
+	if value := os.Getenv("QUDOSH_SOCKET_MODE"); value != "" {
+		m, err := strconv.ParseUint(value, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid QUDOSH_SOCKET_MODE: %w", err)
+		}
+		mode = os.FileMode(m)
+	}
+
+	options := []tty.Option{tty.WithWatchSocket(filepath.Join(dir, sessionID+".sock"), mode)}
+	if writers := os.Getenv("QUDOSH_SHARED_WRITERS"); writers != "" {
+		options = append(options, tty.WithSharedWrite(strings.Split(writers, ",")...))
+	}
+	if supervisors := os.Getenv("QUDOSH_SUPERVISORS"); supervisors != "" {
+		options = append(options, tty.WithSupervisors(strings.Split(supervisors, ",")...))
+	}
+
+	return options, nil
+}
+
+// currentUser is the user introducing itself to a session socket. Where the
+// platform provides peer credentials, the session relies on them instead.
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+// watch attaches read-only to a running session: qudosh watch [session].
+// Without a session, the running sessions are listed.
+func watch(args []string) int {
+	return attachSession(args, tty.ModeWatch)
+}
+
+// join takes part in a running session: qudosh join <session>.
+// Keystrokes are passed on if the owner allowed the user to write.
+func join(args []string) int {
+	if len(args) == 0 {
+		fmt.Println("usage: qudosh join <session>")
+		return 2
+	}
+	return attachSession(args, tty.ModeJoin)
+}
+
+func attachSession(args []string, mode tty.SessionMode) int {
+	if len(args) == 0 {
+		return listSessions()
+	}
+
+	socket, err := sessionSocket(args[0])
+	if err != nil {
+		return exit(err, 1)
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return exit(err, 1)
+	}
+	defer conn.Close()
+
+	if err := tty.WriteHello(conn, tty.Hello{Mode: mode, User: currentUser()}); err != nil {
+		return exit(err, 1)
+	}
+
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		oldState, err := terminal.MakeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			return exit(err, 1)
+		}
+		defer terminal.Restore(int(os.Stdin.Fd()), oldState)
+	}
+
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				return
+			}
+			input := buf[:n]
+			detach := bytes.IndexByte(input, detachKey)
+			if detach >= 0 {
+				input = input[:detach]
+			}
+			if mode != tty.ModeWatch && len(input) > 0 {
+				tty.WriteMessage(conn, tty.MessageInput, input)
+			}
+			if detach >= 0 {
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+	// Attaching as the owner, the session follows the local terminal size.
+	// Otherwise the local terminal is compared with the one of the operator.
+	sizes := &watchSizes{title: fmt.Sprintf("qudosh %s %s", mode, args[0])}
+	resized := func() {
+		if mode == tty.ModeAttach {
+			sendSize(conn)
+		} else {
+			sizes.check()
+		}
+	}
+	sigwinch := make(chan os.Signal, 1)
+	signal.Notify(sigwinch, syscall.SIGWINCH)
+	defer signal.Stop(sigwinch)
+	go func() {
+		for range sigwinch {
+			resized()
+		}
+	}()
+	if mode == tty.ModeAttach {
+		resized()
+	}
+
+	reason := "detached from the session"
+	for {
+		t, payload, err := tty.ReadMessage(conn)
+		if err != nil {
+			break
+		}
+
+		switch t {
+		case tty.MessageOutput:
+			os.Stdout.Write(payload)
+		case tty.MessageResize:
+			size, err := tty.DecodeSize(payload)
+			if err != nil || mode == tty.ModeAttach {
+				continue
+			}
+			sizes.operatorResized(size)
+		case tty.MessageClose:
+			reason = string(payload)
+		}
+	}
+
+	fmt.Printf("\r\nqudosh: %s\r\n", reason)
+	return 0
+}
+
+// sendSize sends the size of the local terminal to the session.
+func sendSize(conn net.Conn) {
+	rows, cols, err := pty.Getsize(os.Stdin)
+	if err != nil {
+		return
+	}
+	tty.WriteMessage(conn, tty.MessageResize, tty.EncodeSize(tty.TerminalSize{Columns: cols, Rows: rows}))
+}
+
+// watchSizes compares the terminal size of the operator with the local one.
+// When the local terminal is smaller, it asks the terminal to grow and shows
+// both sizes in the window title, as the output is laid out for the operator.
+type watchSizes struct {
+	title string
+
+	mu       sync.Mutex
+	operator tty.TerminalSize
+}
+
+func (w *watchSizes) operatorResized(size tty.TerminalSize) {
+	w.mu.Lock()
+	w.operator = size
+	w.mu.Unlock()
+
+	if size.Columns > 0 && size.Rows > 0 {
+		// Terminals allowing window operations (e.g. xterm) follow this request.
+		fmt.Printf("\x1b[8;%d;%dt", size.Rows, size.Columns)
+	}
+	w.check()
+}
+
+func (w *watchSizes) check() {
+	w.mu.Lock()
+	operator := w.operator
+	w.mu.Unlock()
+
+	title := w.title
+	rows, cols, err := pty.Getsize(os.Stdout)
+	if err == nil && operator.Columns > 0 && (cols < operator.Columns || rows < operator.Rows) {
+		title += fmt.Sprintf(" (operator %dx%d, yours %dx%d)", operator.Columns, operator.Rows, cols, rows)
+	}
+	fmt.Printf("\x1b]2;%s\x07", title)
+}